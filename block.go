@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// particleBlockFormat is the format of the particle lines written by this
+// version. Blocks opened by the bare ParticleSeparatorLine are format 1.
+const particleBlockFormat = 2
+
+// blockMeta is written after ParticleSeparatorLine on the opening line,
+// so older versions still recognize the marker, e.g.
+//
+//	// ---------------- AUTO GENRATE BY PARTICLE ---------------- format=2 v=v1.4.0 rules=1a2b hash=3c4d opts=removeD:false
+//
+// In coordination mode it also records the device that wrote the lines
+// and when, e.g. device=ABCDEFG time=2026-01-02T12:00:00Z.
+type blockMeta struct {
+	Format int
+	// programVersion of particle that wrote the lines
+	Version string
+	// ruleSetHash of the rules that generated the lines
+	Rules string
+	// linesHash of the lines, without any timestamp
+	Hash string
+	// generation options, see generatorOptions
+	Options string
+	// Syncthing device ID of the device that wrote the lines
+	Device string
+	Time   time.Time
+}
+
+// parseBlockMeta reads the metadata of an opening separator line.
+func parseBlockMeta(line string) blockMeta {
+	_, rest, _ := strings.Cut(line, ParticleSeparatorLine)
+	var meta blockMeta
+	for _, field := range strings.Fields(rest) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "format":
+			meta.Format, _ = strconv.Atoi(value)
+		case "v":
+			meta.Version = value
+		case "rules":
+			meta.Rules = value
+		case "hash":
+			meta.Hash = value
+		case "opts":
+			meta.Options = value
+		case "device":
+			meta.Device = value
+		case "time":
+			meta.Time, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	if meta.Format == 0 {
+		meta.Format = 1
+	}
+	return meta
+}
+
+// Line returns the opening separator line carrying the metadata.
+func (m blockMeta) Line() string {
+	line := fmt.Sprintf("%s format=%d v=%s", ParticleSeparatorLine, m.Format, m.Version)
+	if m.Rules != "" {
+		line += " rules=" + m.Rules
+	}
+	line += " hash=" + m.Hash
+	if m.Options != "" {
+		line += " opts=" + m.Options
+	}
+	if m.Device != "" {
+		line += " device=" + m.Device + " time=" + m.Time.UTC().Format(time.RFC3339)
+	}
+	return line
+}
+
+// Same reports whether m and o describe the same lines, generated the
+// same way and written by the same device. The version and time of the
+// write are not compared.
+func (m blockMeta) Same(o blockMeta) bool {
+	return m.Format == o.Format && m.Rules == o.Rules && m.Hash == o.Hash && m.Options == o.Options && m.Device == o.Device
+}
+
+// programVersion returns the module version particle was built from,
+// (devel) for a local build.
+func programVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return strings.ReplaceAll(info.Main.Version, " ", "")
+}
+
+// linesHash returns a short content hash of lines.
+func linesHash(lines []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ruleSetHash identifies the enabled rules, their versions and whether
+// they are deletable.
+func ruleSetHash(rules []IgnoreRule) string {
+	ids := make([]string, 0, len(rules))
+	for _, r := range rules {
+		ids = append(ids, fmt.Sprintf("%s@%d:%t", r.Name, r.Version, r.Deletable))
+	}
+	slices.Sort(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// generatorOptions returns the settings changing the generated lines,
+// without spaces.
+func generatorOptions(removeD bool, scan ScanSettings) string {
+	options := fmt.Sprintf("removeD:%t,symlinks:%s,maxDepth:%d,nested:%t,mounts:%t,stop:%s",
+		removeD, scan.Symlinks, scan.MaxDepth, scan.StopAtNestedFolders, !scan.OneFileSystem, strings.Join(scan.StopDirs, "+"))
+	if scan.Proactive {
+		// only written when set, existing blocks stay up to date
+		options += ",proactive"
+	}
+	return options
+}
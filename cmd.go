@@ -0,0 +1,714 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/doraemonkeys/mylog"
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"scan", "[flags] [dir...]", "detect ignore patterns and print them, nothing is written", runScan},
+		{"diff", "[flags] [dir...]", "show the changes apply would make to .stignore", runDiff},
+		{"apply", "[flags] [dir...]", "write detected patterns to .stignore and restart Syncthing", runApply},
+		{"revert", "[flags] [dir...]", "remove the particle block from .stignore", runRevert},
+		{"migrate", "[flags] [dir...]", "rewrite the (?d) prefixes of existing particle blocks", runMigrate},
+		{"prune", "[flags] [dir...]", "drop the particle lines kept by retention", runPrune},
+		{"conflicts", "[flags] [dir...]", "merge and remove sync-conflict copies of .stignore", runConflicts},
+		{"rules", "list", "list the built-in ignore rules", runRules},
+		{"explain", "[flags] <path>", "explain why a path is ignored or not", runExplain},
+		{"watch", "[flags] [dir...]", "run apply periodically until interrupted", runWatch},
+		{"doctor", "[flags]", "check the config, Syncthing connection and .stignore files", runDoctor},
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: particle <command> [flags]\n\nCommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(out, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun 'particle <command> -h' for the flags of a command.\n")
+}
+
+// run executes the command line and returns the exit code.
+func run(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return exitOK
+	}
+	if strings.HasPrefix(name, "-") {
+		// legacy usage without command, e.g. `particle -dir xxx`
+		name = "apply"
+		args = append([]string{name}, args...)
+	}
+	for _, c := range commands() {
+		if c.name != name {
+			continue
+		}
+		err := c.run(args[1:])
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		}
+		logger.Errorf("%s: %v", name, err)
+		if errors.Is(err, errPartialFailure) {
+			return exitPartialFailure
+		}
+		return exitFailure
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command: %s\n\n", name)
+	usage()
+	return exitUsage
+}
+
+// errUsage is returned when the command line is invalid,
+// the flag set has already printed the reason.
+var errUsage = errors.New("invalid usage")
+
+// commonFlags are the flags shared by all commands.
+// Only the flags set explicitly override the config.
+type commonFlags struct {
+	configPath string
+	host       string
+	user       string
+	pwdFile    string
+	syncthing  string
+	web        bool
+	dir        string
+	logLevel   string
+	removeD    bool
+	disable    string
+	enable     string
+	symlinks   string
+	output     string
+	proactive  bool
+}
+
+func newFlagSet(name string, args string) (*flag.FlagSet, *commonFlags) {
+	fset := flag.NewFlagSet("particle "+name, flag.ContinueOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: particle %s %s\n", name, args)
+		fset.PrintDefaults()
+	}
+	cf := &commonFlags{}
+	fset.StringVar(&cf.configPath, "config", "", "config file (default: <user config dir>/particle/config.toml)")
+	fset.StringVar(&cf.host, "host", defaultHost, "syncthing host")
+	fset.StringVar(&cf.user, "user", "", "syncthing user")
+	fset.StringVar(&cf.pwdFile, "pwdFile", "", "syncthing password file")
+	fset.StringVar(&cf.syncthing, "syncthing", "", "syncthing executable file")
+	fset.BoolVar(&cf.web, "web", false, "get all dir from syncthing web api")
+	fset.StringVar(&cf.dir, "dir", "", "target directory")
+	fset.StringVar(&cf.logLevel, "logLevel", defaultLogLevel, "log level")
+	// remove ignore with '(?d)' prefix
+	fset.BoolVar(&cf.removeD, "removeD", false, "remove ignore with '(?d)' prefix")
+	fset.StringVar(&cf.disable, "disable", "", "comma separated rules to disable, see `particle rules list`")
+	fset.StringVar(&cf.enable, "enable", "", "comma separated opt-in rules to enable")
+	fset.StringVar(&cf.symlinks, "symlinks", symlinkSkip, "symlinked directories: skip, follow or report")
+	fset.BoolVar(&cf.proactive, "proactive", false, "ignore the outputs of detected projects before they exist")
+	fset.StringVar(&cf.output, "output", outputInline, "write patterns inline in .stignore or to the include file "+ParticleIncludeFile)
+	return fset, cf
+}
+
+// parse parses args and resolves the config, positional arguments are
+// returned when dirArgs is false, otherwise they replace the target
+// directories.
+func (cf *commonFlags) parse(fset *flag.FlagSet, args []string, dirArgs bool) (*Config, []string, error) {
+	err := fset.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, err
+		}
+		return nil, nil, errUsage
+	}
+	cfg, err := LoadConfig(cf.configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = cfg.applyEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	fset.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host":
+			cfg.Host = cf.host
+		case "user":
+			cfg.User = cf.user
+		case "pwdFile":
+			cfg.PasswordFile = cf.pwdFile
+		case "syncthing":
+			cfg.Syncthing = cf.syncthing
+		case "web":
+			cfg.Web = cf.web
+		case "dir":
+			cfg.Dirs = []string{cf.dir}
+		case "logLevel":
+			cfg.LogLevel = cf.logLevel
+		case "removeD":
+			cfg.Rules.RemoveD = cf.removeD
+		case "disable":
+			cfg.Rules.Disable = append(cfg.Rules.Disable, strings.Split(cf.disable, ",")...)
+		case "enable":
+			cfg.Rules.Enable = append(cfg.Rules.Enable, strings.Split(cf.enable, ",")...)
+			cfg.Rules.Disable = slices.DeleteFunc(cfg.Rules.Disable, func(name string) bool {
+				return slices.Contains(cfg.Rules.Enable, name)
+			})
+		case "symlinks":
+			cfg.Scan.Symlinks = cf.symlinks
+		case "output":
+			cfg.Output = cf.output
+		case "proactive":
+			cfg.Scan.Proactive = cf.proactive
+		}
+	})
+	err = cfg.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.SetLevel(mylog.PraseLevel(cfg.LogLevel))
+	rest := fset.Args()
+	if dirArgs && len(rest) > 0 {
+		cfg.Dirs = rest
+		cfg.Web = false
+		rest = nil
+	}
+	return cfg, rest, nil
+}
+
+// planFolders scans every selected folder. A folder that fails is
+// recorded in the report and the others are still scanned.
+func planFolders(a *app) ([]*folderPlan, *runReport, error) {
+	report := &runReport{}
+	folders, err := a.Folders(report)
+	if err != nil {
+		return nil, nil, err
+	}
+	var plans []*folderPlan
+	for _, f := range folders {
+		if a.onlyStale {
+			stale, reason, err := a.Stale(f)
+			if err != nil {
+				report.Fail(f, err)
+				continue
+			}
+			if !stale {
+				logger.Infof("particle lines of %s are up to date", f)
+				report.Add(folderResult{Folder: f, Status: statusUnchanged})
+				continue
+			}
+			logger.Infof("particle lines of %s are stale: %s", f, reason)
+		}
+		logger.Infof("scan dir: %s", f)
+		plan, err := a.Plan(f)
+		if err != nil {
+			report.Fail(f, err)
+			continue
+		}
+		a.metrics.ObservePlan(plan)
+		plans = append(plans, plan)
+	}
+	return plans, report, nil
+}
+
+func runScan(args []string) error {
+	fset, cf := newFlagSet("scan", "[flags] [dir...]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	plans, report, err := planFolders(newApp(cfg))
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		fmt.Printf("# %s\n", p.folder)
+		for _, line := range p.proposed {
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+	return report.Err()
+}
+
+func runDiff(args []string) error {
+	fset, cf := newFlagSet("diff", "[flags] [dir...]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	plans, report, err := planFolders(newApp(cfg))
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		added, removed := p.Changes()
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		fmt.Printf("--- %s\n+++ %s (particle)\n", p.stIgnore.FilePath(), p.stIgnore.FilePath())
+		for _, line := range removed {
+			fmt.Println("-" + line)
+		}
+		for _, line := range added {
+			fmt.Println("+" + line)
+		}
+	}
+	return report.Err()
+}
+
+func runApply(args []string) error {
+	fset, cf := newFlagSet("apply", "[flags] [dir...]")
+	sleepSeconds := fset.Int("sleep", 0, "sleep seconds after scan")
+	onlyStale := fset.Bool("stale", false, "only regenerate folders whose particle lines were written with other rules or options, or edited")
+	review := fset.Bool("review", false, "accept or reject each added and removed line, rejected lines are suppressed in the config")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	if *review && !isTerminal(os.Stdin) {
+		return fmt.Errorf("-review needs a terminal")
+	}
+	a := newApp(cfg)
+	a.onlyStale = *onlyStale
+	a.review = *review
+	report, err := applyFolders(a)
+	if *sleepSeconds > 0 {
+		time.Sleep(time.Duration(*sleepSeconds) * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// applyFolders writes the particle block of every selected folder,
+// prints a summary and restarts Syncthing if any folder was updated.
+func applyFolders(a *app) (*runReport, error) {
+	plans, report, err := planFolders(a)
+	if err != nil {
+		return nil, err
+	}
+	var in *bufio.Reader
+	if a.review {
+		in = bufio.NewReader(os.Stdin)
+	}
+	for _, p := range plans {
+		if a.review {
+			err = reviewFolder(a, in, p)
+			if err != nil {
+				report.Fail(p.folder, err)
+				continue
+			}
+		}
+		added, removed := p.Changes()
+		updated, err := p.Apply()
+		if err != nil {
+			a.metrics.WriteFailed(p.folder)
+			report.Fail(p.folder, fmt.Errorf("update %s: %w", p.stIgnore.FilePath(), err))
+			continue
+		}
+		result := folderResult{Folder: p.folder, Status: statusUnchanged}
+		if updated {
+			logger.Infof("Successfully updated settings in %s", p.folder.Root)
+			result = folderResult{Folder: p.folder, Status: statusUpdated, Added: len(added), Removed: len(removed)}
+		} else {
+			logger.Infof("No updates required for %s", p.folder.Root)
+		}
+		report.Add(result)
+	}
+	fmt.Println()
+	report.Print(os.Stdout)
+	restartIfUpdated(a, report.Count(statusUpdated) > 0)
+	a.metrics.RecordRun(report)
+	return report, nil
+}
+
+// reviewFolder asks about the changes of p on the terminal and saves the
+// rejected lines as suppressions of the folder.
+func reviewFolder(a *app, in *bufio.Reader, p *folderPlan) error {
+	rules := a.cfg.FolderSettings(p.folder).Rules(StIgnoreRules)
+	suppressed, err := reviewPlan(in, os.Stdout, p, rules)
+	if err != nil {
+		return err
+	}
+	if len(suppressed) == 0 {
+		return nil
+	}
+	err = a.cfg.AddSuppressions(p.folder, suppressed)
+	if err != nil {
+		return err
+	}
+	logger.Infof("suppressed %d lines for %s in %s", len(suppressed), p.folder, a.cfg.Path())
+	return nil
+}
+
+func restartIfUpdated(a *app, updated bool) {
+	if !updated {
+		logger.Info("no updated")
+		return
+	}
+	if a.conn == nil {
+		return
+	}
+	err := a.conn.RestartSyncThing()
+	if err != nil {
+		a.metrics.APIError()
+		logger.Warnf("restart sync thing error: %v", err)
+	} else {
+		logger.Info("restart sync thing success")
+	}
+}
+
+func runRevert(args []string) error {
+	fset, cf := newFlagSet("revert", "[flags] [dir...]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	a := newApp(cfg)
+	report := &runReport{}
+	folders, err := a.Folders(report)
+	if err != nil {
+		return err
+	}
+	for _, f := range folders {
+		stIgnore, err := NewstIgnoreEdit(filepath.Join(f.Root, ".stignore"))
+		if err != nil {
+			report.Fail(f, err)
+			continue
+		}
+		removed := len(stIgnore.ParticleLines())
+		stIgnore.OverwriteIgnores(nil)
+		updated, err := stIgnore.SetChange()
+		if err != nil {
+			report.Fail(f, fmt.Errorf("revert %s: %w", stIgnore.FilePath(), err))
+			continue
+		}
+		if updated {
+			logger.Infof("removed particle block from %s", stIgnore.FilePath())
+			report.Add(folderResult{Folder: f, Status: statusUpdated, Removed: removed})
+		} else {
+			report.Add(folderResult{Folder: f, Status: statusUnchanged})
+		}
+	}
+	report.Print(os.Stdout)
+	restartIfUpdated(a, report.Count(statusUpdated) > 0)
+	return report.Err()
+}
+
+// runMigrate updates the '(?d)' prefixes of the particle blocks after
+// deletable settings changed, without adding or removing lines.
+func runMigrate(args []string) error {
+	fset, cf := newFlagSet("migrate", "[flags] [dir...]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	a := newApp(cfg)
+	plans, report, err := planFolders(a)
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		p.Migrate()
+		added, removed := p.Changes()
+		updated, err := p.Apply()
+		if err != nil {
+			report.Fail(p.folder, fmt.Errorf("update %s: %w", p.stIgnore.FilePath(), err))
+			continue
+		}
+		if updated {
+			logger.Infof("migrated %s", p.stIgnore.FilePath())
+			report.Add(folderResult{Folder: p.folder, Status: statusUpdated, Added: len(added), Removed: len(removed)})
+		} else {
+			report.Add(folderResult{Folder: p.folder, Status: statusUnchanged})
+		}
+	}
+	report.Print(os.Stdout)
+	restartIfUpdated(a, report.Count(statusUpdated) > 0)
+	return report.Err()
+}
+
+// runPrune applies the folders without the lines retention keeps for
+// projects not detected anymore.
+func runPrune(args []string) error {
+	fset, cf := newFlagSet("prune", "[flags] [dir...]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	a := newApp(cfg)
+	plans, report, err := planFolders(a)
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		for _, line := range p.Prune() {
+			logger.Infof("prune %s from %s", line, p.stIgnore.FilePath())
+		}
+		added, removed := p.Changes()
+		updated, err := p.Apply()
+		if err != nil {
+			report.Fail(p.folder, fmt.Errorf("update %s: %w", p.stIgnore.FilePath(), err))
+			continue
+		}
+		if updated {
+			report.Add(folderResult{Folder: p.folder, Status: statusUpdated, Added: len(added), Removed: len(removed)})
+		} else {
+			report.Add(folderResult{Folder: p.folder, Status: statusUnchanged})
+		}
+	}
+	report.Print(os.Stdout)
+	restartIfUpdated(a, report.Count(statusUpdated) > 0)
+	return report.Err()
+}
+
+// runConflicts shows the Syncthing conflict copies of .stignore, of the
+// include file and of the included files, then merges and removes them after confirmation.
+func runConflicts(args []string) error {
+	fset, cf := newFlagSet("conflicts", "[flags] [dir...]")
+	yes := fset.Bool("yes", false, "do not ask for confirmation")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	a := newApp(cfg)
+	report := &runReport{}
+	folders, err := a.Folders(report)
+	if err != nil {
+		return err
+	}
+	in := bufio.NewReader(os.Stdin)
+	for _, f := range folders {
+		copies, err := findIgnoreConflicts(f.Root)
+		if err != nil {
+			report.Fail(f, err)
+			continue
+		}
+		if len(copies) == 0 {
+			report.Add(folderResult{Folder: f, Status: statusUnchanged})
+			continue
+		}
+		fmt.Printf("# %s\n", f)
+		for _, c := range copies {
+			err = printConflictDiff(os.Stdout, c)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			report.Fail(f, err)
+			continue
+		}
+		fmt.Println()
+		if !*yes && !confirm(in, fmt.Sprintf("merge and remove %d conflict copies?", len(copies))) {
+			report.Add(folderResult{Folder: f, Status: statusUnchanged})
+			continue
+		}
+		result, err := resolveConflicts(a, f, copies)
+		if err != nil {
+			report.Fail(f, err)
+			continue
+		}
+		report.Add(result)
+	}
+	report.Print(os.Stdout)
+	restartIfUpdated(a, report.Count(statusUpdated) > 0)
+	return report.Err()
+}
+
+func runRules(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: particle rules list [flags]")
+		return errUsage
+	}
+	fset, cf := newFlagSet("rules list", "[flags]")
+	cfg, _, err := cf.parse(fset, args[1:], false)
+	if err != nil {
+		return err
+	}
+	disabled := cfg.FolderSettings(syncFolder{}).DisabledRules
+	for _, r := range StIgnoreRules {
+		state := "enabled"
+		if disabled[r.Name] {
+			state = "disabled"
+		}
+		if r.OptIn {
+			state += "*"
+		}
+		if r.Proactive != nil {
+			state += "+"
+		}
+		fmt.Printf("%-13s v%-3d %-9s %-52s markers: %s\n", r.Name, r.Version, state, r.Description, strings.Join(r.Markers, ", "))
+	}
+	fmt.Println("\n* opt-in rule, enable it with -enable or rules.enable in the config")
+	fmt.Println("+ writes patterns from the markers alone with -proactive or scan.proactive")
+	return nil
+}
+
+func runExplain(args []string) error {
+	fset, cf := newFlagSet("explain", "[flags] <path>")
+	cfg, rest, err := cf.parse(fset, args, false)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		fset.Usage()
+		return errUsage
+	}
+	target, err := expandHome(rest[0])
+	if err != nil {
+		return err
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	folder, err := newApp(cfg).EnclosingFolder(target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(folder.Root, target)
+	if err != nil {
+		return err
+	}
+	e, err := explainPath(folder, rel, cfg.FolderSettings(folder).Rules(StIgnoreRules))
+	if err != nil {
+		return err
+	}
+	e.Print()
+	return nil
+}
+
+func runWatch(args []string) error {
+	fset, cf := newFlagSet("watch", "[flags] [dir...]")
+	interval := fset.Duration("interval", 10*time.Minute, "time between two runs")
+	serveMetrics := fset.Bool("metrics", false, "serve Prometheus metrics on /metrics and the folder results on /status")
+	listen := fset.String("listen", "", "address of the metrics server (default "+defaultMetricsListen+")")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	if *serveMetrics {
+		cfg.Metrics.Enabled = true
+	}
+	if *listen != "" {
+		cfg.Metrics.Listen = *listen
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a := newApp(cfg)
+	serveErr := make(chan error, 1)
+	if cfg.Metrics.Enabled {
+		ln, err := net.Listen("tcp", cfg.Metrics.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen for metrics: %w", err)
+		}
+		a.metrics = newMetrics()
+		go func() {
+			serveErr <- a.metrics.Serve(ctx, ln)
+		}()
+		logger.Infof("serving metrics on http://%s/metrics", ln.Addr())
+	}
+	for {
+		report, err := applyFolders(a)
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil {
+			logger.Errorf("watch: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-serveErr:
+			return err
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func runDoctor(args []string) error {
+	fset, cf := newFlagSet("doctor", "[flags]")
+	cfg, _, err := cf.parse(fset, args, true)
+	if err != nil {
+		return err
+	}
+	var failed int
+	report := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("[ OK ] %s\n", name)
+	}
+
+	if cfg.Path() != "" {
+		report("config "+cfg.Path(), nil)
+	} else {
+		fmt.Println("[ -- ] no config file, using defaults")
+	}
+	a := newApp(cfg)
+	if cfg.Web {
+		_, err := a.Conn()
+		report("syncthing "+cfg.Host, err)
+		if err != nil {
+			return fmt.Errorf("%d check(s) failed", failed)
+		}
+	}
+	unresolved := &runReport{}
+	folders, err := a.Folders(unresolved)
+	report("folders", err)
+	for _, result := range unresolved.Results {
+		report("folder "+result.Folder.String(), result.Err)
+	}
+	for _, f := range folders {
+		_, err := os.Stat(f.Root)
+		report("folder "+f.String(), err)
+		if err != nil {
+			continue
+		}
+		stIgnore, err := NewstIgnoreEdit(filepath.Join(f.Root, ".stignore"))
+		if err == nil {
+			_, err = stIgnore.GetBaseIgnoreCheckFunc()
+		}
+		report(filepath.Join(f.Root, ".stignore"), err)
+		if err == nil && len(stIgnore.ParticleLines()) > 0 {
+			if stale, reason := stIgnore.Stale(a.generator(f)); stale {
+				fmt.Printf("[ -- ] particle lines of %s are stale: %s, run particle apply\n", f, reason)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	defaultHost        = "http://127.0.0.1:8384"
+	defaultLogLevel    = "info"
+	defaultPasswordEnv = "SYNCTHING_PASSWORD"
+	defaultMaxEntries  = 50000
+	defaultGracePeriod = 24 * time.Hour
+	// Prometheus metrics and status of watch, localhost only
+	defaultMetricsListen = "127.0.0.1:9469"
+)
+
+// Environment variables, they take precedence over the config file
+// but are overridden by command line flags.
+const (
+	envConfig       = "PARTICLE_CONFIG"
+	envHost         = "PARTICLE_HOST"
+	envUser         = "PARTICLE_USER"
+	envPasswordFile = "PARTICLE_PASSWORD_FILE"
+	envWeb          = "PARTICLE_WEB"
+	envSyncthing    = "PARTICLE_SYNCTHING"
+	envLogLevel     = "PARTICLE_LOG_LEVEL"
+)
+
+// Config holds all settings of particle.
+// Precedence: flags > env > config file > defaults.
+type Config struct {
+	// Syncthing GUI address
+	Host string `toml:"host"`
+	// Syncthing GUI user
+	User string `toml:"user"`
+	// File containing the Syncthing GUI password
+	PasswordFile string `toml:"password_file"`
+	// Environment variable containing the Syncthing GUI password
+	PasswordEnv string `toml:"password_env"`
+	// Get all folders from the Syncthing web api
+	Web bool `toml:"web"`
+	// Syncthing executable file, used to resolve relative folder paths
+	Syncthing string `toml:"syncthing"`
+	LogLevel  string `toml:"log_level"`
+	// Local folders to work on when Web is false
+	Dirs []string `toml:"dirs"`
+	// Where the particle lines are written: inline or include
+	Output string `toml:"output"`
+	// what to do with lines edited by hand in the particle block: move,
+	// pin or abort
+	ManualEdits string `toml:"manual_edits"`
+
+	Folders FolderFilter `toml:"folders"`
+	Rules   RuleSettings `toml:"rules"`
+	Scan    ScanSettings `toml:"scan"`
+	// for .stignore files synced between devices running particle
+	Coordination CoordinationSettings `toml:"coordination"`
+	Retention    RetentionSettings    `toml:"retention"`
+	Metrics      MetricsSettings      `toml:"metrics"`
+	Folder       []FolderOverride     `toml:"folder"`
+
+	// file the config was loaded from, empty if none
+	path string
+}
+
+// FolderFilter selects folders by Syncthing folder ID or path glob.
+// An empty Include list selects every folder.
+type FolderFilter struct {
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+}
+
+type RuleSettings struct {
+	// remove ignore with '(?d)' prefix
+	RemoveD bool `toml:"remove_d"`
+	// names of the rules turned off for every folder
+	Disable []string `toml:"disable"`
+	// names of the opt-in rules turned on for every folder
+	Enable []string `toml:"enable"`
+	// overrides whether the patterns of a rule get the '(?d)' prefix
+	Deletable map[string]bool `toml:"deletable"`
+}
+
+// ScanSettings tells the scanner where to stop descending.
+type ScanSettings struct {
+	// directory names never scanned, e.g. version control metadata
+	StopDirs []string `toml:"stop_dirs"`
+	// do not scan directories containing .stfolder, they are other
+	// Syncthing folders
+	StopAtNestedFolders bool `toml:"stop_at_nested_folders"`
+	// do not cross mount points
+	OneFileSystem bool `toml:"one_file_system"`
+	// maximum depth below the folder root, 0 for no limit
+	MaxDepth int `toml:"max_depth"`
+	// directories with more entries are checked by the rules but their
+	// children are not scanned, 0 for no limit
+	MaxEntries int `toml:"max_entries"`
+	// what to do with symlinked directories: skip, follow or report
+	Symlinks string `toml:"symlinks"`
+	// also write the patterns of rules from the project markers alone,
+	// before the outputs exist
+	Proactive bool `toml:"proactive"`
+}
+
+// CoordinationSettings makes devices sharing the particle lines merge
+// their results instead of overwriting each other.
+type CoordinationSettings struct {
+	Enabled bool `toml:"enabled"`
+	// defaults to the Syncthing device ID with -web, or the host name
+	DeviceID string `toml:"device_id"`
+	// lines written by another device are not removed for this long
+	GracePeriod time.Duration `toml:"grace_period"`
+}
+
+// RetentionSettings keeps particle lines for a while after their project
+// is not detected anymore, e.g. while node_modules is reinstalled. A line
+// is dropped when the first limit is reached.
+type RetentionSettings struct {
+	// 0 for no time limit
+	KeepFor time.Duration `toml:"keep_for"`
+	// number of apply runs, 0 for no limit
+	KeepRuns int `toml:"keep_runs"`
+	// defaults to <user cache dir>/particle/state.json
+	StateFile string `toml:"state_file"`
+}
+
+// Enabled reports whether any limit is set.
+func (r RetentionSettings) Enabled() bool {
+	return r.KeepFor > 0 || r.KeepRuns > 0
+}
+
+// Expired reports whether a line missing since the given time for the
+// given number of runs is dropped.
+func (r RetentionSettings) Expired(l retainedLine, now time.Time) bool {
+	return (r.KeepFor > 0 && now.Sub(l.Since) >= r.KeepFor) || (r.KeepRuns > 0 && l.Runs > r.KeepRuns)
+}
+
+// MetricsSettings serves Prometheus metrics on /metrics and the last
+// result of each folder on /status while watching.
+type MetricsSettings struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+}
+
+// Output modes.
+const (
+	// a block between ParticleSeparatorLine markers in .stignore
+	outputInline = "inline"
+	// ParticleIncludeFile, included from .stignore
+	outputInclude = "include"
+)
+
+// Policies for lines edited by hand in the particle block.
+const (
+	// move them above the block, to the user written lines
+	editsMove = "move"
+	// keep them in a pinned section of the block, never rewritten
+	editsPin = "pin"
+	// fail without writing the folder
+	editsAbort = "abort"
+)
+
+// Symlink policies of the scanner.
+const (
+	symlinkSkip   = "skip"
+	symlinkFollow = "follow"
+	// like skip, with a warning for each symlinked directory
+	symlinkReport = "report"
+)
+
+// FolderOverride changes settings for the folders matching ID or Path.
+// Path may be a glob.
+type FolderOverride struct {
+	ID      string `toml:"id"`
+	Path    string `toml:"path"`
+	Skip    bool   `toml:"skip"`
+	RemoveD *bool  `toml:"remove_d"`
+	// rules turned off for this folder
+	DisableRules []string `toml:"disable_rules"`
+	// rules turned on for this folder, opt-in or globally disabled ones
+	EnableRules []string `toml:"enable_rules"`
+	// overrides whether the patterns of a rule get the '(?d)' prefix
+	Deletable map[string]bool `toml:"deletable"`
+	// particle lines never written for this folder, e.g. rejected in
+	// review, the '(?d)' prefix is not compared
+	Suppress []string `toml:"suppress"`
+}
+
+// folderSettings is the effective configuration for one folder.
+type folderSettings struct {
+	Skip          bool
+	RemoveD       bool
+	DisabledRules map[string]bool
+	// deletable overrides by rule name
+	Deletable map[string]bool
+	Suppress  []string
+}
+
+// Suppressed reports whether line must not be written for the folder.
+func (s folderSettings) Suppressed(line string) bool {
+	return slices.ContainsFunc(s.Suppress, func(suppressed string) bool {
+		return strings.Replace(suppressed, "(?d)", "", 1) == strings.Replace(line, "(?d)", "", 1)
+	})
+}
+
+// Rules returns the rules of all that are not disabled, with the
+// deletable overrides applied.
+func (s folderSettings) Rules(all []IgnoreRule) []IgnoreRule {
+	var rules []IgnoreRule
+	for _, r := range all {
+		if s.DisabledRules[r.Name] {
+			continue
+		}
+		if deletable, ok := s.Deletable[r.Name]; ok {
+			r.Deletable = deletable
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Host:        defaultHost,
+		PasswordEnv: defaultPasswordEnv,
+		LogLevel:    defaultLogLevel,
+		Output:      outputInline,
+		ManualEdits: editsPin,
+		Coordination: CoordinationSettings{
+			GracePeriod: defaultGracePeriod,
+		},
+		Metrics: MetricsSettings{
+			Listen: defaultMetricsListen,
+		},
+		Scan: ScanSettings{
+			StopDirs:            []string{".git", ".hg", ".svn"},
+			StopAtNestedFolders: true,
+			OneFileSystem:       true,
+			MaxEntries:          defaultMaxEntries,
+			Symlinks:            symlinkSkip,
+		},
+	}
+}
+
+// DefaultConfigPath returns the config file in the user config dir,
+// e.g. ~/.config/particle/config.toml.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+	return filepath.Join(dir, "particle", "config.toml"), nil
+}
+
+// LoadConfig reads the config file on top of the defaults.
+// If configPath is empty, $PARTICLE_CONFIG or the default path is used,
+// a missing default config file is not an error.
+func LoadConfig(configPath string) (*Config, error) {
+	cfg := defaultConfig()
+	explicit := true
+	if configPath == "" {
+		configPath = os.Getenv(envConfig)
+	}
+	if configPath == "" {
+		explicit = false
+		p, err := DefaultConfigPath()
+		if err != nil {
+			return cfg, nil
+		}
+		configPath = p
+	}
+	configPath, err := expandHome(configPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = toml.DecodeFile(configPath, cfg)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to load config %s: %w", configPath, err)
+	}
+	cfg.path = configPath
+	return cfg, nil
+}
+
+// applyEnv overrides the config with the PARTICLE_* environment variables.
+func (c *Config) applyEnv() error {
+	if v := os.Getenv(envHost); v != "" {
+		c.Host = v
+	}
+	if v := os.Getenv(envUser); v != "" {
+		c.User = v
+	}
+	if v := os.Getenv(envPasswordFile); v != "" {
+		c.PasswordFile = v
+	}
+	if v := os.Getenv(envSyncthing); v != "" {
+		c.Syncthing = v
+	}
+	if v := os.Getenv(envLogLevel); v != "" {
+		c.LogLevel = v
+	}
+	if v := os.Getenv(envWeb); v != "" {
+		web, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", envWeb, err)
+		}
+		c.Web = web
+	}
+	return nil
+}
+
+// Path returns the file the config was loaded from, empty if none.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Selected reports whether the folder passes the folder filters.
+func (c *Config) Selected(f syncFolder) bool {
+	if len(c.Folders.Include) > 0 && !matchAnyFolder(c.Folders.Include, f) {
+		return false
+	}
+	return !matchAnyFolder(c.Folders.Exclude, f)
+}
+
+// FolderSettings resolves the effective settings for a folder,
+// later overrides win over earlier ones.
+func (c *Config) FolderSettings(f syncFolder) folderSettings {
+	s := folderSettings{
+		RemoveD:       c.Rules.RemoveD,
+		DisabledRules: make(map[string]bool),
+		Deletable:     maps.Clone(c.Rules.Deletable),
+	}
+	if s.Deletable == nil {
+		s.Deletable = make(map[string]bool)
+	}
+	for _, r := range StIgnoreRules {
+		if r.OptIn {
+			s.DisabledRules[r.Name] = true
+		}
+	}
+	for _, name := range c.Rules.Enable {
+		delete(s.DisabledRules, name)
+	}
+	for _, name := range c.Rules.Disable {
+		s.DisabledRules[name] = true
+	}
+	for _, o := range c.Folder {
+		if !o.Matches(f) {
+			continue
+		}
+		if o.Skip {
+			s.Skip = true
+		}
+		if o.RemoveD != nil {
+			s.RemoveD = *o.RemoveD
+		}
+		for _, name := range o.DisableRules {
+			s.DisabledRules[name] = true
+		}
+		for _, name := range o.EnableRules {
+			delete(s.DisabledRules, name)
+		}
+		maps.Copy(s.Deletable, o.Deletable)
+		s.Suppress = append(s.Suppress, o.Suppress...)
+	}
+	return s
+}
+
+// AddSuppressions suppresses lines for f and saves them to the config
+// file, in a new override with the folder ID, or its path for local
+// folders. The override is appended so the rest of the file is kept as
+// written, overrides of the same folder add up.
+func (c *Config) AddSuppressions(f syncFolder, lines []string) error {
+	configPath := c.path
+	if configPath == "" {
+		p, err := DefaultConfigPath()
+		if err != nil {
+			return err
+		}
+		configPath = p
+	}
+	current := c.FolderSettings(f).Suppress
+	var added []string
+	for _, line := range lines {
+		if !slices.Contains(current, line) && !slices.Contains(added, line) {
+			added = append(added, line)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	override := FolderOverride{ID: f.ID, Suppress: added}
+	entry := map[string]any{"id": f.ID, "suppress": added}
+	if f.ID == "" {
+		override = FolderOverride{Path: f.Root, Suppress: added}
+		entry = map[string]any{"path": f.Root, "suppress": added}
+	}
+	content, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load config %s: %w", configPath, err)
+	}
+	var b bytes.Buffer
+	b.Write(content)
+	if len(content) > 0 {
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	enc := toml.NewEncoder(&b)
+	enc.Indent = ""
+	err = enc.Encode(map[string]any{"folder": []map[string]any{entry}})
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(configPath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+	err = os.WriteFile(configPath, b.Bytes(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write config %s: %w", configPath, err)
+	}
+	c.path = configPath
+	c.Folder = append(c.Folder, override)
+	return nil
+}
+
+// Validate checks the rule names used in the config.
+func (c *Config) Validate() error {
+	err := validateRuleNames(c.Rules.Disable)
+	if err != nil {
+		return fmt.Errorf("rules.disable: %w", err)
+	}
+	err = validateRuleNames(c.Rules.Enable)
+	if err != nil {
+		return fmt.Errorf("rules.enable: %w", err)
+	}
+	err = validateRuleNames(slices.Collect(maps.Keys(c.Rules.Deletable)))
+	if err != nil {
+		return fmt.Errorf("rules.deletable: %w", err)
+	}
+	if c.Retention.KeepFor < 0 || c.Retention.KeepRuns < 0 {
+		return fmt.Errorf("retention.keep_for and retention.keep_runs must not be negative")
+	}
+	if c.Scan.MaxDepth < 0 || c.Scan.MaxEntries < 0 {
+		return fmt.Errorf("scan.max_depth and scan.max_entries must not be negative")
+	}
+	if c.Output != outputInline && c.Output != outputInclude {
+		return fmt.Errorf("invalid output %q, use %s or %s", c.Output, outputInline, outputInclude)
+	}
+	switch c.ManualEdits {
+	case editsMove, editsPin, editsAbort:
+	default:
+		return fmt.Errorf("invalid manual_edits policy %q, use %s, %s or %s", c.ManualEdits, editsMove, editsPin, editsAbort)
+	}
+	switch c.Scan.Symlinks {
+	case symlinkSkip, symlinkFollow, symlinkReport:
+	default:
+		return fmt.Errorf("invalid symlink policy %q, use %s, %s or %s", c.Scan.Symlinks, symlinkSkip, symlinkFollow, symlinkReport)
+	}
+	for _, o := range c.Folder {
+		if o.ID == "" && o.Path == "" {
+			return fmt.Errorf("folder override without id or path")
+		}
+		names := append(slices.Clone(o.DisableRules), o.EnableRules...)
+		err = validateRuleNames(append(names, slices.Collect(maps.Keys(o.Deletable))...))
+		if err != nil {
+			return fmt.Errorf("folder %s%s: %w", o.ID, o.Path, err)
+		}
+	}
+	return nil
+}
+
+func validateRuleNames(names []string) error {
+	for _, name := range names {
+		if _, ok := findRule(name); !ok {
+			return fmt.Errorf("unknown rule %q, see `particle rules list`", name)
+		}
+	}
+	return nil
+}
+
+func (o FolderOverride) Matches(f syncFolder) bool {
+	if o.ID != "" && o.ID != f.ID {
+		return false
+	}
+	if o.Path != "" && !matchFolderPath(o.Path, f) {
+		return false
+	}
+	return o.ID != "" || o.Path != ""
+}
+
+func matchAnyFolder(patterns []string, f syncFolder) bool {
+	for _, p := range patterns {
+		if p == f.ID && f.ID != "" {
+			return true
+		}
+		if matchFolderPath(p, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFolderPath matches a path or glob against the folder path as
+// configured and against its resolved local root.
+func matchFolderPath(pattern string, f syncFolder) bool {
+	if p, err := expandHome(pattern); err == nil {
+		pattern = p
+	}
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	for _, p := range []string{f.Path, f.Root} {
+		if p == "" {
+			continue
+		}
+		p = strings.TrimSuffix(filepath.ToSlash(p), "/")
+		if p == pattern {
+			return true
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandHome replaces a leading "~/" with the user home directory.
+func expandHome(p string) (string, error) {
+	slashed := filepath.ToSlash(p)
+	if !strings.HasPrefix(slashed, "~/") {
+		return p, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, slashed[2:]), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(p, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return p
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	p := writeTestConfig(t, `
+host = "http://file:8384"
+user = "file-user"
+log_level = "debug"
+`)
+	t.Setenv(envConfig, "")
+	t.Setenv(envHost, "http://env:8384")
+	t.Setenv(envUser, "")
+
+	fset, cf := newFlagSet("test", "")
+	cfg, _, err := cf.parse(fset, []string{"-config", p, "-user", "flag-user"}, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Host != "http://env:8384" {
+		t.Errorf("env should override config file, got host %s", cfg.Host)
+	}
+	if cfg.User != "flag-user" {
+		t.Errorf("flag should override config file, got user %s", cfg.User)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("config file should override default, got log level %s", cfg.LogLevel)
+	}
+	if cfg.PasswordEnv != defaultPasswordEnv {
+		t.Errorf("Unexpected default password env: %s", cfg.PasswordEnv)
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml"))
+	if err == nil {
+		t.Fatalf("Expected error for missing explicit config, got nil")
+	}
+}
+
+func TestFolderSettings(t *testing.T) {
+	p := writeTestConfig(t, `
+[folders]
+exclude = ["photos"]
+
+[rules]
+remove_d = true
+
+[[folder]]
+id = "code"
+remove_d = false
+
+[[folder]]
+path = "/data/tmp/*"
+skip = true
+`)
+	cfg, err := LoadConfig(p)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	code := syncFolder{ID: "code", Path: "/data/code", Root: "/data/code"}
+	if !cfg.Selected(code) || cfg.FolderSettings(code).RemoveD {
+		t.Errorf("Unexpected settings for %s: %+v", code, cfg.FolderSettings(code))
+	}
+	photos := syncFolder{ID: "photos", Path: "/data/photos", Root: "/data/photos"}
+	if cfg.Selected(photos) {
+		t.Errorf("Expected %s to be excluded", photos)
+	}
+	tmp := syncFolder{ID: "tmp1", Path: "/data/tmp/a", Root: "/data/tmp/a"}
+	if s := cfg.FolderSettings(tmp); !s.Skip || !s.RemoveD {
+		t.Errorf("Unexpected settings for %s: %+v", tmp, s)
+	}
+}
+
+func TestDeletableSettings(t *testing.T) {
+	p := writeTestConfig(t, `
+[rules.deletable]
+nodejs = false
+
+[[folder]]
+id = "games"
+deletable = { unity = true }
+`)
+	cfg, err := LoadConfig(p)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	deletable := func(f syncFolder, name string) bool {
+		for _, r := range cfg.FolderSettings(f).Rules(StIgnoreRules) {
+			if r.Name == name {
+				return r.Deletable
+			}
+		}
+		t.Fatalf("rule %s not found", name)
+		return false
+	}
+	games := syncFolder{ID: "games", Root: "/data/games"}
+	other := syncFolder{ID: "other", Root: "/data/other"}
+	if deletable(other, "nodejs") || deletable(games, "nodejs") {
+		t.Errorf("Expected nodejs not to be deletable")
+	}
+	if deletable(other, "unity") || !deletable(games, "unity") {
+		t.Errorf("Expected unity to be deletable only in games")
+	}
+	if !deletable(other, "rust") {
+		t.Errorf("Expected rust to be deletable")
+	}
+}
+
+func TestAddSuppressions(t *testing.T) {
+	p := writeTestConfig(t, `
+# written by hand
+output = "include"
+
+[[folder]]
+id = "games"
+suppress = ["/old"]
+`)
+	cfg, err := LoadConfig(p)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	games := syncFolder{ID: "games", Root: "/data/games"}
+	local := syncFolder{Path: "/data/local", Root: "/data/local"}
+	if err = cfg.AddSuppressions(games, []string{"(?d)/build", "/old"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = cfg.AddSuppressions(local, []string{"/dist"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cfg, err = LoadConfig(p)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Output != outputInclude {
+		t.Errorf("Expected other settings to be kept, got output %q", cfg.Output)
+	}
+	if s := cfg.FolderSettings(games); !s.Suppressed("/build") || !s.Suppressed("/old") || s.Suppressed("/dist") {
+		t.Errorf("Unexpected suppressions for games: %v", s.Suppress)
+	}
+	if s := cfg.FolderSettings(local); !s.Suppressed("(?d)/dist") || len(s.Suppress) != 1 {
+		t.Errorf("Unexpected suppressions for local: %v", s.Suppress)
+	}
+	if s := cfg.FolderSettings(games); len(s.Suppress) != 2 {
+		t.Errorf("Expected suppressions not to be repeated, got %v", s.Suppress)
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "\n# written by hand\n") {
+		t.Errorf("Expected the config to be kept as written, got:\n%s", content)
+	}
+}
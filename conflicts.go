@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// syncConflictInfix is inserted by Syncthing before the extension of a
+// conflicting file, e.g. .stignore becomes
+// .sync-conflict-20260102-120000-ABCDEFG.stignore and .stignore.particle
+// becomes .stignore.sync-conflict-20260102-120000-ABCDEFG.particle.
+var syncConflictInfix = regexp.MustCompile(`\.sync-conflict-\d{8}-\d{6}-[A-Z0-9]{7}`)
+
+// conflictCopy is a Syncthing conflict copy of an ignore file.
+type conflictCopy struct {
+	Path     string
+	Original string
+}
+
+// findIgnoreConflicts returns the conflict copies of .stignore, of
+// ParticleIncludeFile and of the files included by .stignore.
+func findIgnoreConflicts(root string) ([]conflictCopy, error) {
+	files, err := ignoreFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	var copies []conflictCopy
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		matches, err := filepath.Glob(strings.TrimSuffix(file, ext) + ".sync-conflict-*" + ext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find conflict copies: %w", err)
+		}
+		for _, match := range matches {
+			if original, ok := conflictOriginal(match); ok && original == file {
+				copies = append(copies, conflictCopy{Path: match, Original: file})
+			}
+		}
+	}
+	slices.SortFunc(copies, func(a, b conflictCopy) int { return strings.Compare(a.Path, b.Path) })
+	return copies, nil
+}
+
+// conflictOriginal returns the file a conflict copy was made of, ok is
+// false if copyPath is not a conflict copy.
+func conflictOriginal(copyPath string) (original string, ok bool) {
+	name := filepath.Base(copyPath)
+	loc := syncConflictInfix.FindStringIndex(name)
+	if loc == nil {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(copyPath), name[:loc[0]]+name[loc[1]:]), true
+}
+
+// ignoreFiles returns .stignore, ParticleIncludeFile and the files
+// included by .stignore, following #include.
+func ignoreFiles(root string) ([]string, error) {
+	files := []string{filepath.Join(root, ".stignore"), filepath.Join(root, ParticleIncludeFile)}
+	for i := 0; i < len(files); i++ {
+		lines, err := readNonEmptyLines(files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, line := range lines {
+			fields := strings.SplitN(line, " ", 2)
+			if fields[0] != "#include" || len(fields) != 2 {
+				continue
+			}
+			included := filepath.Join(filepath.Dir(files[i]), filepath.FromSlash(strings.TrimSpace(fields[1])))
+			if !slices.Contains(files, included) {
+				files = append(files, included)
+			}
+		}
+	}
+	return files, nil
+}
+
+// printConflictDiff writes the lines only in the original as '-' and the
+// lines only in the conflict copy as '+'.
+func printConflictDiff(w io.Writer, c conflictCopy) error {
+	originalLines, err := readNonEmptyLines(c.Original)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	copyLines, err := readNonEmptyLines(c.Path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", c.Original, c.Path)
+	for _, line := range originalLines {
+		if !slices.Contains(copyLines, line) {
+			fmt.Fprintf(w, "-%s\n", line)
+		}
+	}
+	for _, line := range copyLines {
+		if !slices.Contains(originalLines, line) {
+			fmt.Fprintf(w, "+%s\n", line)
+		}
+	}
+	return nil
+}
+
+func readNonEmptyLines(p string) ([]string, error) {
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// resolveConflicts merges each conflict copy into its original:
+// the user written lines of a .stignore copy into .stignore, the missing
+// lines of an included file copy into that file. Copies of
+// ParticleIncludeFile only hold generated lines and are just removed.
+// The particle lines are then regenerated and the copies removed, the
+// folder is unchanged if nothing was written.
+func resolveConflicts(a *app, f syncFolder, copies []conflictCopy) (folderResult, error) {
+	stIgnore, err := a.openStIgnore(f)
+	if err != nil {
+		return folderResult{}, err
+	}
+	// keep the block metadata, only the merged lines are written here
+	stIgnore.SetGenerator(a.generator(f))
+	var merged int
+	for _, c := range copies {
+		switch c.Original {
+		case stIgnore.filePath:
+			conflict, err := NewstIgnoreEdit(c.Path)
+			if err != nil {
+				return folderResult{}, err
+			}
+			// lines already in the particle lines of .stignore are not new
+			lines := slices.DeleteFunc(conflict.BaseLines(), func(line string) bool {
+				return slices.Contains(stIgnore.ParticleLines(), line) || slices.Contains(stIgnore.PinnedLines(), line)
+			})
+			merged += len(stIgnore.MergeBaseLines(lines))
+		case stIgnore.includeFilePath():
+		default:
+			n, err := mergeIncludedConflict(c)
+			if err != nil {
+				return folderResult{}, err
+			}
+			merged += n
+		}
+	}
+	// included files were written when merged
+	updated := merged > 0
+	stUpdated, err := stIgnore.SetChange()
+	if err != nil {
+		return folderResult{}, fmt.Errorf("update %s: %w", stIgnore.FilePath(), err)
+	}
+	plan, err := a.Plan(f)
+	if err != nil {
+		return folderResult{}, err
+	}
+	added, removed := plan.Changes()
+	planUpdated, err := plan.Apply()
+	if err != nil {
+		return folderResult{}, fmt.Errorf("update %s: %w", plan.stIgnore.FilePath(), err)
+	}
+	for _, c := range copies {
+		err = os.Remove(c.Path)
+		if err != nil {
+			return folderResult{}, fmt.Errorf("failed to remove conflict copy: %w", err)
+		}
+		logger.Infof("removed %s", c.Path)
+	}
+	if !updated && !stUpdated && !planUpdated {
+		return folderResult{Folder: f, Status: statusUnchanged}, nil
+	}
+	return folderResult{Folder: f, Status: statusUpdated, Added: merged + len(added), Removed: len(removed)}, nil
+}
+
+// mergeIncludedConflict appends the lines of the copy of an included
+// file missing from the original and returns how many were appended.
+func mergeIncludedConflict(c conflictCopy) (int, error) {
+	originalLines, err := readNonEmptyLines(c.Original)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	copyLines, err := readNonEmptyLines(c.Path)
+	if err != nil {
+		return 0, err
+	}
+	var missing []string
+	for _, line := range copyLines {
+		if !slices.Contains(originalLines, line) && !slices.Contains(missing, line) {
+			missing = append(missing, line)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	// append to keep the blank lines and comments of the original
+	content, err := os.ReadFile(c.Original)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read %s: %w", c.Original, err)
+	}
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, strings.Join(missing, "\n")+"\n"...)
+	err = os.WriteFile(c.Original, content, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", c.Original, err)
+	}
+	return len(missing), nil
+}
+
+// confirm asks a yes/no question, anything but y or yes is no. in is
+// shared by the questions of a run so buffered answers are not lost.
+func confirm(in *bufio.Reader, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := in.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
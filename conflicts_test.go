@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	root := makeTree(t, "app/Cargo.toml", "app/Cargo.lock", "app/target/")
+	files := map[string]string{
+		".stignore": "/photos\n#include extra.txt\n",
+		"extra.txt": "// extra\n/music\n",
+		"extra.sync-conflict-20260102-120000-ABCDEFG.txt":          "/music\n/books\n",
+		".sync-conflict-20260102-120000-ABCDEFG.stignore":          "/photos\n#include extra.txt\n/videos\n\n" + ParticleSeparatorLine + "\n/old\n" + ParticleSeparatorLine + "\n",
+		".stignore.sync-conflict-20260102-120000-ABCDEFG.particle": "/old\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	copies, err := findIgnoreConflicts(root)
+	if err != nil || len(copies) != 3 {
+		t.Fatalf("Expected 3 conflict copies, got %v, %v", copies, err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Rules.RemoveD = true
+	result, err := resolveConflicts(newApp(cfg), syncFolder{Root: root}, copies)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != statusUpdated || result.Added != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	if got := stIgnore.BaseLines(); !reflect.DeepEqual(got, []string{"/photos", "#include extra.txt", "/videos"}) {
+		t.Errorf("Unexpected base lines: %v", got)
+	}
+	if got := stIgnore.ParticleLines(); !reflect.DeepEqual(got, []string{"/app/target"}) {
+		t.Errorf("Unexpected particle lines: %v", got)
+	}
+	extra, err := os.ReadFile(filepath.Join(root, "extra.txt"))
+	if err != nil || string(extra) != "// extra\n/music\n/books\n" {
+		t.Errorf("Unexpected extra.txt: %q, %v", extra, err)
+	}
+	if copies, _ := findIgnoreConflicts(root); len(copies) != 0 {
+		t.Errorf("Expected conflict copies to be removed, got %v", copies)
+	}
+}
+
+func TestConflictOriginal(t *testing.T) {
+	tests := []struct {
+		copyPath string
+		want     string
+		ok       bool
+	}{
+		{"/f/.sync-conflict-20260102-120000-ABCDEFG.stignore", "/f/.stignore", true},
+		{"/f/.stignore.sync-conflict-20260102-120000-ABCDEFG.particle", "/f/.stignore.particle", true},
+		{"/f/extra.sync-conflict-20260102-120000-ABCDEFG.txt", "/f/extra.txt", true},
+		{"/f/.stignore.sync-conflict-notes", "", false},
+		{"/f/.stignore", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := conflictOriginal(filepath.FromSlash(tt.copyPath))
+		if ok != tt.ok || got != filepath.FromSlash(tt.want) {
+			t.Errorf("Unexpected original of %s. Got %q, %v, expected %q, %v", tt.copyPath, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestResolveConflictsUnchanged(t *testing.T) {
+	root := makeTree(t, "Cargo.toml", "Cargo.lock", "target/")
+	cfg := defaultConfig()
+	cfg.Rules.RemoveD = true
+	p, err := newApp(cfg).Plan(syncFolder{Root: root})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if _, err = p.Apply(); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	// the particle line moved above the block on the other device
+	copyContent := "/target\n" + string(content)
+	copyPath := filepath.Join(root, ".sync-conflict-20260102-120000-ABCDEFG.stignore")
+	if err = os.WriteFile(copyPath, []byte(copyContent), 0644); err != nil {
+		t.Fatalf("Failed to write conflict copy: %v", err)
+	}
+	copies, err := findIgnoreConflicts(root)
+	if err != nil || len(copies) != 1 {
+		t.Fatalf("Expected 1 conflict copy, got %v, %v", copies, err)
+	}
+	result, err := resolveConflicts(newApp(cfg), syncFolder{Root: root}, copies)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Status != statusUnchanged || result.Added != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	after, _ := os.ReadFile(filepath.Join(root, ".stignore"))
+	if string(after) != string(content) {
+		t.Errorf("Expected .stignore to be kept. Got:\n%s\nExpected:\n%s", after, content)
+	}
+	if _, err = os.Stat(copyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the conflict copy to be removed, got %v", err)
+	}
+}
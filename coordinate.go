@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// coordinator merges the lines detected on this device with the ones
+// written by other devices sharing the same .stignore, so they converge
+// instead of rewriting each other's lines.
+type coordinator struct {
+	device string
+	grace  time.Duration
+	now    time.Time
+}
+
+// Merge returns the union of current and detected. A current line not
+// detected here is only dropped when the directory it is anchored in is
+// gone, and never within the grace period after another device wrote.
+// A current line detected with another (?d) prefix is replaced by the
+// detected one.
+func (c coordinator) Merge(root string, meta blockMeta, current, detected []string) []string {
+	recentOther := meta.Device != "" && meta.Device != c.device && c.now.Sub(meta.Time) < c.grace
+	merged := make([]string, 0, len(current)+len(detected))
+	for _, line := range current {
+		i := slices.IndexFunc(detected, func(d string) bool { return withoutDeletable(d) == withoutDeletable(line) })
+		switch {
+		case i >= 0:
+			if !slices.Contains(merged, detected[i]) {
+				merged = append(merged, detected[i])
+			}
+		case recentOther || !anchorMissing(root, line):
+			merged = append(merged, line)
+		}
+	}
+	for _, line := range detected {
+		if !slices.Contains(merged, line) {
+			merged = append(merged, line)
+		}
+	}
+	return merged
+}
+
+// anchorMissing reports whether the parent directory of an anchored
+// pattern line does not exist under root anymore.
+func anchorMissing(root string, line string) bool {
+	pattern, _ := splitNegation(line)
+	pattern = strings.ReplaceAll(strings.ReplaceAll(pattern, "(?d)", ""), "(?i)", "")
+	if !strings.HasPrefix(pattern, "/") {
+		return false
+	}
+	dir := path.Dir(pattern)
+	_, err := os.Stat(filepath.Join(root, filepath.FromSlash(dir)))
+	return os.IsNotExist(err)
+}
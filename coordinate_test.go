@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoordinatorMerge(t *testing.T) {
+	root := makeTree(t, "a/", "b/")
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	c := coordinator{device: "LOCAL", grace: time.Hour, now: now}
+	current := []string{"(?d)/a/target", "(?d)/gone/target", "(?d)/b/node_modules"}
+	detected := []string{"(?d)/a/target", "(?d)/b/dist"}
+
+	cases := []struct {
+		name     string
+		meta     blockMeta
+		expected []string
+	}{
+		{"RecentOther", blockMeta{Device: "OTHER", Time: now.Add(-time.Minute)},
+			[]string{"(?d)/a/target", "(?d)/gone/target", "(?d)/b/node_modules", "(?d)/b/dist"}},
+		{"OldOther", blockMeta{Device: "OTHER", Time: now.Add(-2 * time.Hour)},
+			[]string{"(?d)/a/target", "(?d)/b/node_modules", "(?d)/b/dist"}},
+		{"Local", blockMeta{Device: "LOCAL", Time: now.Add(-time.Minute)},
+			[]string{"(?d)/a/target", "(?d)/b/node_modules", "(?d)/b/dist"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := c.Merge(root, tc.meta, current, detected)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Unexpected lines. Got %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCoordinatorMergeDeletableChange(t *testing.T) {
+	root := makeTree(t, "app/node_modules/", "app/dist/")
+	c := coordinator{device: "LOCAL", grace: time.Hour, now: time.Now()}
+	current := []string{"(?d)/app/node_modules", "(?d)/app/dist"}
+	detected := []string{"/app/node_modules", "/app/dist"}
+	got := c.Merge(root, blockMeta{Device: "LOCAL"}, current, detected)
+	if !reflect.DeepEqual(got, detected) {
+		t.Errorf("Unexpected lines. Got %v, expected %v", got, detected)
+	}
+}
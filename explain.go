@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/syncthing/syncthing/lib/fs"
+	"github.com/syncthing/syncthing/lib/ignore"
+)
+
+// ignoreLine is a pattern line of .stignore or of a file it includes.
+type ignoreLine struct {
+	Text string
+	// File the line was read from, relative to the folder root
+	File string
+	Num  int
+	// The line is inside the particle block
+	Particle bool
+}
+
+// ruleOrigin is a rule that generates a particle line, with the marker
+// files that made it match.
+type ruleOrigin struct {
+	Rule    IgnoreRule
+	Markers []string
+}
+
+// explanation tells why a path is ignored or not.
+type explanation struct {
+	Folder syncFolder
+	// Path relative to the folder root
+	Path    string
+	Ignored bool
+	// First line matching Path, nil if none
+	Line *ignoreLine
+	// Directory the particle line was generated for, relative to the folder root
+	Dir     string
+	Origins []ruleOrigin
+}
+
+// explainPath evaluates rel against the .stignore of folder line by line,
+// the first matching line decides like in Syncthing.
+func explainPath(folder syncFolder, rel string, rules []IgnoreRule) (*explanation, error) {
+	rel = path.Clean(filepath.ToSlash(rel))
+	e := &explanation{Folder: folder, Path: rel}
+	filesystem := fs.NewFilesystem(fs.FilesystemTypeBasic, folder.Root)
+	matcher := ignore.New(filesystem)
+	err := matcher.Load(".stignore")
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return e, nil
+		}
+		return nil, fmt.Errorf("failed to load %s: %w", filepath.Join(folder.Root, ".stignore"), err)
+	}
+	e.Ignored = rel != "." && matcher.Match(rel).IsIgnored()
+
+	lines, err := readIgnoreLines(folder.Root, ".stignore", make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		matched, err := lineMatches(filesystem, line.Text, rel)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", line.File, line.Num, err)
+		}
+		if matched {
+			e.Line = &lines[i]
+			break
+		}
+	}
+	if e.Line != nil && e.Line.Particle {
+		e.Dir, e.Origins = particleOrigins(folder.Root, e.Line.Text, rules)
+	}
+	return e, nil
+}
+
+// readIgnoreLines reads the pattern lines of file, following #include.
+// Lines already seen are skipped as Syncthing does.
+func readIgnoreLines(root, file string, seen map[string]bool) ([]ignoreLine, error) {
+	content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var lines []ignoreLine
+	var inParticle, pinned bool
+	for i, text := range strings.Split(string(content), "\n") {
+		text = strings.TrimSpace(text)
+		if strings.Contains(text, ParticleSeparatorLine) {
+			inParticle = !inParticle
+			continue
+		}
+		switch text {
+		case particlePinnedStart:
+			pinned = true
+		case particlePinnedEnd:
+			pinned = false
+		}
+		if text == "" || strings.HasPrefix(text, "//") || seen[text] {
+			continue
+		}
+		seen[text] = true
+		if strings.HasPrefix(text, "#include") {
+			fields := strings.SplitN(text, " ", 2)
+			if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+				return nil, fmt.Errorf("%s:%d: failed to parse #include line", file, i+1)
+			}
+			includeFile := path.Join(path.Dir(file), filepath.ToSlash(strings.TrimSpace(fields[1])))
+			included, err := readIgnoreLines(root, includeFile, seen)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, included...)
+			continue
+		}
+		// pinned lines are user written
+		particle := (inParticle || path.Base(file) == ParticleIncludeFile) && !pinned
+		lines = append(lines, ignoreLine{Text: text, File: file, Num: i + 1, Particle: particle})
+	}
+	return lines, nil
+}
+
+// lineMatches reports whether the single pattern line matches rel,
+// negated lines count as matching too.
+func lineMatches(filesystem fs.Filesystem, line string, rel string) (bool, error) {
+	pattern, _ := splitNegation(line)
+	matcher := ignore.New(filesystem)
+	err := matcher.Parse(strings.NewReader(pattern), ".stignore")
+	if err != nil {
+		return false, err
+	}
+	return matcher.Match(rel).IsIgnored(), nil
+}
+
+// splitNegation removes the '!' prefix from a pattern line, keeping the
+// other prefixes, which may come in any order.
+func splitNegation(line string) (pattern string, negated bool) {
+	var prefixes string
+	for {
+		switch {
+		case strings.HasPrefix(line, "!") && !negated:
+			negated = true
+			line = line[1:]
+		case strings.HasPrefix(line, "(?i)"), strings.HasPrefix(line, "(?d)"):
+			prefixes += line[:4]
+			line = line[4:]
+		default:
+			return prefixes + line, negated
+		}
+	}
+}
+
+// particleOrigins runs the rules again on the directory a particle line
+// was generated for and returns the ones producing it. Rules may generate
+// nested paths such as "project/target", so every parent is tried.
+func particleOrigins(root string, line string, rules []IgnoreRule) (dir string, origins []ruleOrigin) {
+	pattern, _ := splitNegation(line)
+	pattern = strings.ReplaceAll(strings.ReplaceAll(pattern, "(?d)", ""), "(?i)", "")
+	for _, rule := range rules {
+		if slices.Contains(rule.Global, pattern) {
+			origins = append(origins, ruleOrigin{Rule: rule})
+		}
+	}
+	if len(origins) > 0 {
+		return "", origins
+	}
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		dir = strings.Join(parts[:i], "/")
+		name := strings.Join(parts[i:], "/")
+		absDir := filepath.Join(root, filepath.FromSlash(dir))
+		entries, err := os.ReadDir(absDir)
+		if err != nil {
+			continue
+		}
+		for _, rule := range rules {
+			// the line may have been written in proactive mode
+			if slices.ContainsFunc(rule.ProactiveIgnores(absDir, entries), func(i Ignore) bool { return i.Name == name }) {
+				origins = append(origins, ruleOrigin{Rule: rule, Markers: rule.FoundMarkers(absDir, entries)})
+			}
+		}
+		if len(origins) > 0 {
+			return dir, origins
+		}
+	}
+	return "", nil
+}
+
+// Print writes the explanation in a human readable form.
+func (e *explanation) Print() {
+	fmt.Printf("folder:  %s\n", e.Folder)
+	fmt.Printf("path:    %s\n", e.Path)
+	if e.Ignored {
+		fmt.Println("result:  ignored")
+	} else {
+		fmt.Println("result:  not ignored")
+	}
+	if e.Line == nil {
+		fmt.Println("line:    no line matches")
+		return
+	}
+	fmt.Printf("line:    %s:%d %s\n", e.Line.File, e.Line.Num, e.Line.Text)
+	if !e.Line.Particle {
+		fmt.Println("source:  user")
+		return
+	}
+	fmt.Println("source:  particle")
+	if len(e.Origins) == 0 {
+		fmt.Println("rule:    no rule generates this line anymore, it is removed on next apply")
+		return
+	}
+	for _, origin := range e.Origins {
+		fmt.Printf("rule:    %s (%s)\n", origin.Rule.Name, origin.Rule.Description)
+		if len(origin.Markers) == 0 {
+			fmt.Println("markers: none, global pattern")
+			continue
+		}
+		markers := make([]string, 0, len(origin.Markers))
+		for _, m := range origin.Markers {
+			markers = append(markers, path.Join(e.Dir, m))
+		}
+		fmt.Printf("markers: %s\n", strings.Join(markers, ", "))
+	}
+}
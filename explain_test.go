@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplainPath(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"app/target", "docs/build"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+	}
+	for _, file := range []string{"app/Cargo.toml", "app/Cargo.lock"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	content := "build\n!keep\n" + ParticleSeparatorLine + "\n(?d)/app/target\n" + ParticleSeparatorLine + "\n"
+	if err := os.WriteFile(filepath.Join(root, ".stignore"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create .stignore: %v", err)
+	}
+	folder := syncFolder{Path: root, Root: root}
+
+	e, err := explainPath(folder, "app/target/debug", StIgnoreRules)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !e.Ignored || e.Line == nil || !e.Line.Particle || e.Line.Num != 4 {
+		t.Fatalf("Unexpected explanation: %+v", e)
+	}
+	if len(e.Origins) != 1 || e.Origins[0].Rule.Name != "rust" || len(e.Origins[0].Markers) != 2 {
+		t.Errorf("Unexpected origins: %+v", e.Origins)
+	}
+
+	e, err = explainPath(folder, "docs/build", StIgnoreRules)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !e.Ignored || e.Line == nil || e.Line.Particle || e.Line.Text != "build" {
+		t.Errorf("Unexpected explanation: %+v", e)
+	}
+
+	e, err = explainPath(folder, "keep", StIgnoreRules)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if e.Ignored || e.Line == nil || e.Line.Text != "!keep" {
+		t.Errorf("Unexpected explanation: %+v", e)
+	}
+}
@@ -119,7 +119,8 @@ func (s *syncThingConn) Connect(password string) error {
 	return nil
 }
 
-func (s *syncThingConn) FetchDirectories() ([]string, error) {
+// FetchFolders returns the folders from the Syncthing config.
+func (s *syncThingConn) FetchFolders() ([]syncFolder, error) {
 	if !s.authPassed {
 		return nil, fmt.Errorf("not connected, please pass auth first")
 	}
@@ -154,33 +155,77 @@ func (s *syncThingConn) FetchDirectories() ([]string, error) {
 
 	var config struct {
 		Folders []struct {
-			Path string `json:"path"`
+			ID    string `json:"id"`
+			Label string `json:"label"`
+			Path  string `json:"path"`
 		} `json:"folders"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
 		return nil, fmt.Errorf("error decoding config response: %v", err)
 	}
 
-	var dirs []string
+	var folders []syncFolder
 	for _, folder := range config.Folders {
-		dirs = append(dirs, folder.Path)
+		folders = append(folders, syncFolder{
+			ID:    folder.ID,
+			Label: folder.Label,
+			Path:  folder.Path,
+		})
 	}
 
-	return dirs, nil
+	return folders, nil
 }
 
-func (s *syncThingConn) ReadPassword(pwdFile ...string) (string, error) {
-	if len(pwdFile) > 0 && doraemon.FileIsExist(pwdFile[0]).IsTrue() {
-		content, err := os.ReadFile(pwdFile[0])
+// MyID returns the device ID of the Syncthing instance.
+func (s *syncThingConn) MyID() (string, error) {
+	if !s.authPassed {
+		return "", fmt.Errorf("not connected, please pass auth first")
+	}
+	statusURL := fmt.Sprintf("%s/rest/system/status", s.host)
+	req, err := http.NewRequest("GET", statusURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating status request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	const CSRFTokenName = "CSRF-Token" // e.g. CSRF-Token-7APTNV7
+	for _, cookie := range s.client.Jar.Cookies(req.URL) {
+		if strings.HasPrefix(cookie.Name, CSRFTokenName) {
+			req.Header.Set("x-"+strings.ToLower(cookie.Name), cookie.Value)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending status request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get status with status code: %d", resp.StatusCode)
+	}
+	var status struct {
+		MyID string `json:"myID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return "", fmt.Errorf("error decoding status response: %v", err)
+	}
+	return status.MyID, nil
+}
+
+// ReadPassword reads the password from pwdFile, then from the
+// environment variable envName, and finally prompts for it.
+func (s *syncThingConn) ReadPassword(pwdFile string, envName string) (string, error) {
+	if pwdFile != "" && doraemon.FileIsExist(pwdFile).IsTrue() {
+		content, err := os.ReadFile(pwdFile)
 		if err != nil {
 			return "", fmt.Errorf("error reading password file: %v", err)
 		}
 		return string(content), nil
 	}
-	const ENV_PASSWORD = "SYNCTHING_PASSWORD"
-	password := os.Getenv(ENV_PASSWORD)
-	if password != "" {
-		return password, nil
+	if envName != "" {
+		password := os.Getenv(envName)
+		if password != "" {
+			return password, nil
+		}
 	}
 
 	fmt.Print("Enter password: ")
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// syncFolder is a directory managed by particle, either given on the
+// command line or fetched from the Syncthing config.
+type syncFolder struct {
+	// Syncthing folder ID, empty for local folders
+	ID    string
+	Label string
+	// Path as configured
+	Path string
+	// Absolute local path
+	Root string
+}
+
+func (f syncFolder) String() string {
+	if f.ID == "" {
+		return f.Root
+	}
+	return fmt.Sprintf("%s (%s)", f.Root, f.ID)
+}
+
+// app holds the resolved config and the Syncthing connection shared by
+// the commands.
+type app struct {
+	cfg     *Config
+	conn    *syncThingConn
+	scanner *dirScanner
+	// this device in coordination mode, resolved on first use
+	deviceID string
+	// only plan folders whose particle lines are stale
+	onlyStale bool
+	// ask about each changed line before writing
+	review bool
+	// nil unless serving metrics
+	metrics *metrics
+	// retention state, loaded on first use
+	state *retentionState
+}
+
+func newApp(cfg *Config) *app {
+	return &app{
+		cfg:     cfg,
+		scanner: NewDirScanner(StIgnoreRules, cfg.Syncthing),
+	}
+}
+
+// Conn returns the Syncthing connection, logging in on first use.
+func (a *app) Conn() (*syncThingConn, error) {
+	if a.conn != nil {
+		return a.conn, nil
+	}
+	conn, err := NewSyncThingConn(a.cfg.User, a.cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	pwdFile, err := expandHome(a.cfg.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	pwd, err := conn.ReadPassword(pwdFile, a.cfg.PasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Connect(pwd)
+	if err != nil {
+		a.metrics.APIError()
+		return nil, err
+	}
+	a.conn = conn
+	return conn, nil
+}
+
+// DeviceID identifies this device in the header of the particle lines:
+// the configured ID, the Syncthing device ID with -web, or the host name.
+func (a *app) DeviceID() (string, error) {
+	if a.deviceID != "" {
+		return a.deviceID, nil
+	}
+	a.deviceID = a.cfg.Coordination.DeviceID
+	if a.deviceID == "" && a.cfg.Web {
+		conn, err := a.Conn()
+		if err != nil {
+			return "", err
+		}
+		a.deviceID, err = conn.MyID()
+		if err != nil {
+			a.metrics.APIError()
+			return "", err
+		}
+	}
+	if a.deviceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get device id: %w", err)
+		}
+		a.deviceID = hostname
+	}
+	return a.deviceID, nil
+}
+
+// State returns the retention state, loaded on first use.
+func (a *app) State() (*retentionState, error) {
+	if a.state != nil {
+		return a.state, nil
+	}
+	path := a.cfg.Retention.StateFile
+	if path == "" {
+		p, err := DefaultStatePath()
+		if err != nil {
+			return nil, err
+		}
+		path = p
+	}
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	a.state, err = loadState(path)
+	if err != nil {
+		return nil, err
+	}
+	return a.state, nil
+}
+
+// Folders returns the folders to work on, filtered by the config.
+// Folders whose path can't be resolved are added to report as failed.
+func (a *app) Folders(report *runReport) ([]syncFolder, error) {
+	var folders []syncFolder
+	if a.cfg.Web {
+		conn, err := a.Conn()
+		if err != nil {
+			return nil, err
+		}
+		folders, err = conn.FetchFolders()
+		if err != nil {
+			a.metrics.APIError()
+			return nil, err
+		}
+	} else {
+		for _, dir := range a.cfg.Dirs {
+			folders = append(folders, syncFolder{Path: dir})
+		}
+	}
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("no folder to scan, use -dir, -web or the config file")
+	}
+
+	var selected []syncFolder
+	for _, f := range folders {
+		root, err := a.scanner.prepareDirectory(f.Path, a.cfg.Web)
+		if err != nil {
+			f.Root = f.Path
+			report.Fail(f, err)
+			continue
+		}
+		f.Root = root
+		if !a.cfg.Selected(f) {
+			logger.Debugf("folder %s is filtered out", f)
+			continue
+		}
+		if a.cfg.FolderSettings(f).Skip {
+			logger.Debugf("folder %s is skipped by config", f)
+			continue
+		}
+		selected = append(selected, f)
+	}
+	return selected, nil
+}
+
+// EnclosingFolder finds the folder containing path. Configured folders
+// are preferred, otherwise the nearest parent with a .stignore or
+// .stfolder is used.
+func (a *app) EnclosingFolder(path string) (syncFolder, error) {
+	folders, err := a.Folders(&runReport{})
+	if err == nil {
+		var best syncFolder
+		for _, f := range folders {
+			if isSubPath(f.Root, path) && len(f.Root) > len(best.Root) {
+				best = f
+			}
+		}
+		if best.Root != "" {
+			return best, nil
+		}
+	}
+	for dir := path; ; {
+		for _, marker := range []string{".stignore", ".stfolder"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return syncFolder{Path: dir, Root: dir}, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return syncFolder{}, fmt.Errorf("no syncthing folder contains %s", path)
+		}
+		dir = parent
+	}
+}
+
+// folderPlan is the result of scanning a folder: the particle lines in
+// .stignore now and the ones particle would write.
+type folderPlan struct {
+	folder   syncFolder
+	stIgnore *stIgnoreEdit
+	current  []string
+	proposed []string
+	// lines kept by retention after this run, nil without retention
+	retained map[string]retainedLine
+	state    *retentionState
+	// time spent and work done by the scan
+	scanTime time.Duration
+	stats    scanStats
+}
+
+// openStIgnore reads the .stignore of f, set up to be written in the
+// configured output mode.
+func (a *app) openStIgnore(f syncFolder) (*stIgnoreEdit, error) {
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(f.Root, ".stignore"))
+	if err != nil {
+		return nil, err
+	}
+	stIgnore.SetIncludeMode(a.cfg.Output == outputInclude)
+	stIgnore.SetSyncIncludeFile(a.cfg.Coordination.Enabled)
+	return stIgnore, nil
+}
+
+// generator returns the ruleSetHash and generatorOptions for f, written
+// in the block metadata.
+func (a *app) generator(f syncFolder) (rulesHash, options string) {
+	settings := a.cfg.FolderSettings(f)
+	return ruleSetHash(settings.Rules(StIgnoreRules)), generatorOptions(settings.RemoveD, a.cfg.Scan)
+}
+
+// Stale reports from the block metadata whether f needs to be scanned
+// again, when only regenerating stale folders.
+func (a *app) Stale(f syncFolder) (bool, string, error) {
+	stIgnore, err := a.openStIgnore(f)
+	if err != nil {
+		return false, "", err
+	}
+	stale, reason := stIgnore.Stale(a.generator(f))
+	return stale, reason, nil
+}
+
+// Plan scans the folder and prepares the new particle block without
+// writing it.
+func (a *app) Plan(f syncFolder) (*folderPlan, error) {
+	stIgnore, err := a.openStIgnore(f)
+	if err != nil {
+		return nil, err
+	}
+	settings := a.cfg.FolderSettings(f)
+	stIgnore.SetGenerator(a.generator(f))
+	scanner := NewDirScanner(settings.Rules(StIgnoreRules), a.cfg.Syncthing)
+	scanner.SetRemoveD(settings.RemoveD)
+	scanner.SetScanSettings(a.cfg.Scan)
+	start := time.Now()
+	ignores, err := scanner.ScanFolder(f.Root, stIgnore)
+	if err != nil {
+		return nil, err
+	}
+	scanTime := time.Since(start)
+	expected, err := a.expectedLines(f, stIgnore, ignores)
+	if err != nil {
+		return nil, err
+	}
+	err = a.keepManualEdits(stIgnore, expected)
+	if err != nil {
+		return nil, err
+	}
+	current := stIgnore.ParticleLines()
+	ignores, retained, err := a.retain(f, current, ignores)
+	if err != nil {
+		return nil, err
+	}
+	ignores = slices.DeleteFunc(ignores, settings.Suppressed)
+	maps.DeleteFunc(retained, func(line string, _ retainedLine) bool { return settings.Suppressed(line) })
+	if a.cfg.Coordination.Enabled {
+		c, err := a.coordinator()
+		if err != nil {
+			return nil, err
+		}
+		ignores = c.Merge(f.Root, stIgnore.DiskMeta(), current, ignores)
+		stIgnore.OverwriteIgnores(ignores)
+		if !slices.Equal(current, stIgnore.ParticleLines()) {
+			stIgnore.SetWriter(c.device, c.now)
+		}
+	} else {
+		stIgnore.OverwriteIgnores(ignores)
+		if !slices.Equal(current, stIgnore.ParticleLines()) {
+			stIgnore.SetWriter("", time.Time{})
+		}
+	}
+	return &folderPlan{
+		folder:   f,
+		stIgnore: stIgnore,
+		current:  current,
+		proposed: stIgnore.ParticleLines(),
+		retained: retained,
+		state:    a.state,
+		scanTime: scanTime,
+		stats:    scanner.Stats(),
+	}, nil
+}
+
+// retain keeps the current lines not detected anymore until a retention
+// limit is reached. It returns the lines to write and the retained lines
+// of the folder, saved when the plan is applied.
+func (a *app) retain(f syncFolder, current, detected []string) ([]string, map[string]retainedLine, error) {
+	r := a.cfg.Retention
+	if !r.Enabled() {
+		return detected, nil, nil
+	}
+	state, err := a.State()
+	if err != nil {
+		return nil, nil, err
+	}
+	previous := state.Folders[f.Root]
+	retained := make(map[string]retainedLine)
+	lines := slices.Clone(detected)
+	now := time.Now()
+	for _, line := range current {
+		// a line detected with another (?d) prefix is replaced, not retained
+		if slices.ContainsFunc(detected, func(d string) bool { return withoutDeletable(d) == withoutDeletable(line) }) {
+			continue
+		}
+		l, ok := previous[line]
+		if !ok {
+			l = retainedLine{Since: now}
+		}
+		l.Runs++
+		if r.Expired(l, now) {
+			logger.Infof("retention of %s in %s expired", line, f)
+			continue
+		}
+		retained[line] = l
+		lines = append(lines, line)
+	}
+	return lines, retained, nil
+}
+
+// expectedLines returns the particle lines that are not manual edits:
+// the detected lines, the lines kept by retention and, in coordination
+// mode, the current lines the merge keeps as written by other devices.
+func (a *app) expectedLines(f syncFolder, stIgnore *stIgnoreEdit, detected []string) ([]string, error) {
+	expected := slices.Clone(detected)
+	if a.cfg.Retention.Enabled() {
+		state, err := a.State()
+		if err != nil {
+			return nil, err
+		}
+		for line := range state.Folders[f.Root] {
+			if !slices.Contains(expected, line) {
+				expected = append(expected, line)
+			}
+		}
+	}
+	if a.cfg.Coordination.Enabled {
+		c, err := a.coordinator()
+		if err != nil {
+			return nil, err
+		}
+		expected = c.Merge(f.Root, stIgnore.DiskMeta(), stIgnore.ParticleLines(), expected)
+	}
+	return expected, nil
+}
+
+// keepManualEdits applies the manual_edits policy when the particle lines
+// were edited by hand: the lines particle would not generate are moved to
+// the base or pinned section, or the folder fails.
+func (a *app) keepManualEdits(stIgnore *stIgnoreEdit, generated []string) error {
+	if !stIgnore.Edited() {
+		return nil
+	}
+	var edited []string
+	for _, line := range stIgnore.ParticleLines() {
+		if !slices.Contains(generated, line) {
+			edited = append(edited, line)
+		}
+	}
+	if len(edited) == 0 {
+		if a.cfg.ManualEdits == editsAbort {
+			return fmt.Errorf("particle lines were removed by hand from %s, use '%s' to remove patterns", stIgnore.FilePath(), ParticleDisableDirective)
+		}
+		logger.Warnf("particle lines removed by hand from %s are written again, use '%s' to remove patterns", stIgnore.FilePath(), ParticleDisableDirective)
+		return nil
+	}
+	switch a.cfg.ManualEdits {
+	case editsAbort:
+		return fmt.Errorf("particle lines in %s were edited by hand: %s; move them above the particle block or set manual_edits to %s or %s",
+			stIgnore.FilePath(), strings.Join(edited, ", "), editsMove, editsPin)
+	case editsMove:
+		stIgnore.MergeBaseLines(edited)
+		stIgnore.RemoveIgnores(edited)
+		logger.Infof("moved %d lines edited by hand in %s to the user written lines", len(edited), stIgnore.FilePath())
+	case editsPin:
+		stIgnore.Pin(edited)
+		logger.Infof("pinned %d lines edited by hand in %s", len(edited), stIgnore.FilePath())
+	}
+	return nil
+}
+
+func (a *app) coordinator() (coordinator, error) {
+	device, err := a.DeviceID()
+	if err != nil {
+		return coordinator{}, err
+	}
+	return coordinator{device: device, grace: a.cfg.Coordination.GracePeriod, now: time.Now()}, nil
+}
+
+// Changes returns the lines added to and removed from the particle block.
+func (p *folderPlan) Changes() (added, removed []string) {
+	for _, line := range p.proposed {
+		if !slices.Contains(p.current, line) {
+			added = append(added, line)
+		}
+	}
+	for _, line := range p.current {
+		if !slices.Contains(p.proposed, line) {
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}
+
+// Migrate keeps the current particle block but takes the '(?d)' prefix of
+// each line from the proposed one, for when deletable settings changed.
+// Lines not proposed anymore are kept as they are.
+func (p *folderPlan) Migrate() {
+	proposed := make(map[string]string, len(p.proposed))
+	for _, line := range p.proposed {
+		key := withoutDeletable(line)
+		if _, ok := p.retained[line]; ok {
+			// the detected line is preferred
+			if _, ok := proposed[key]; ok {
+				continue
+			}
+		}
+		proposed[key] = line
+	}
+	migrated := make([]string, 0, len(p.current))
+	for _, line := range p.current {
+		if newLine, ok := proposed[withoutDeletable(line)]; ok {
+			line = newLine
+		}
+		if !slices.Contains(migrated, line) {
+			migrated = append(migrated, line)
+		}
+	}
+	p.stIgnore.OverwriteIgnores(migrated)
+	p.proposed = p.stIgnore.ParticleLines()
+}
+
+// Prune drops the lines kept by retention from the plan and returns them.
+func (p *folderPlan) Prune() []string {
+	var pruned []string
+	for _, line := range p.proposed {
+		if _, ok := p.retained[line]; ok {
+			pruned = append(pruned, line)
+		}
+	}
+	p.stIgnore.RemoveIgnores(pruned)
+	p.proposed = p.stIgnore.ParticleLines()
+	if p.retained != nil {
+		p.retained = map[string]retainedLine{}
+	}
+	return pruned
+}
+
+// Apply writes the planned particle block, then the retention state.
+func (p *folderPlan) Apply() (updated bool, err error) {
+	updated, err = p.stIgnore.SetChange()
+	if err != nil || p.retained == nil {
+		return updated, err
+	}
+	err = p.state.Update(p.folder.Root, p.retained)
+	if err != nil {
+		return updated, err
+	}
+	return updated, nil
+}
+
+func isSubPath(parent, child string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, "../"))
+}
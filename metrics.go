@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics of a long-running particle, served with the last result of
+// each folder. A nil *metrics records nothing.
+type metrics struct {
+	registry      *prometheus.Registry
+	scanDuration  *prometheus.HistogramVec
+	dirsVisited   *prometheus.CounterVec
+	patterns      *prometheus.GaugeVec
+	writeFailures *prometheus.CounterVec
+	apiErrors     prometheus.Counter
+	lastSuccess   prometheus.Gauge
+
+	mu              sync.Mutex
+	folders         map[string]folderStatusJSON
+	lastRun         time.Time
+	lastSuccessTime time.Time
+}
+
+// folderStatusJSON is the last result of a folder on /status.
+type folderStatusJSON struct {
+	Folder  string       `json:"folder"`
+	ID      string       `json:"id,omitempty"`
+	Status  folderStatus `json:"status"`
+	Added   int          `json:"added"`
+	Removed int          `json:"removed"`
+	Error   string       `json:"error,omitempty"`
+	Time    time.Time    `json:"time"`
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		scanDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "particle_scan_duration_seconds",
+			Help:    "Time spent scanning a folder.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"folder"}),
+		dirsVisited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "particle_scan_directories_total",
+			Help: "Directories read while scanning a folder.",
+		}, []string{"folder"}),
+		patterns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "particle_patterns",
+			Help: "Patterns generated by each rule in the last scan of a folder.",
+		}, []string{"folder", "rule"}),
+		writeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "particle_write_failures_total",
+			Help: "Failed writes of the particle lines of a folder.",
+		}, []string{"folder"}),
+		apiErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "particle_syncthing_api_errors_total",
+			Help: "Failed requests to the Syncthing API.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "particle_last_success_timestamp_seconds",
+			Help: "Unix time of the last run where every folder succeeded.",
+		}),
+		folders: make(map[string]folderStatusJSON),
+	}
+	m.registry.MustRegister(m.scanDuration, m.dirsVisited, m.patterns, m.writeFailures, m.apiErrors, m.lastSuccess)
+	return m
+}
+
+// folderLabel identifies f in the metric labels.
+func folderLabel(f syncFolder) string {
+	if f.ID != "" {
+		return f.ID
+	}
+	return f.Root
+}
+
+// ObservePlan records the scan of a folder.
+func (m *metrics) ObservePlan(p *folderPlan) {
+	if m == nil {
+		return
+	}
+	label := folderLabel(p.folder)
+	m.scanDuration.WithLabelValues(label).Observe(p.scanTime.Seconds())
+	m.dirsVisited.WithLabelValues(label).Add(float64(p.stats.Dirs))
+	m.patterns.DeletePartialMatch(prometheus.Labels{"folder": label})
+	for rule, n := range p.stats.Patterns {
+		m.patterns.WithLabelValues(label, rule).Set(float64(n))
+	}
+}
+
+// WriteFailed records a failed write of the particle lines of f.
+func (m *metrics) WriteFailed(f syncFolder) {
+	if m == nil {
+		return
+	}
+	m.writeFailures.WithLabelValues(folderLabel(f)).Inc()
+}
+
+// APIError records a failed Syncthing API request.
+func (m *metrics) APIError() {
+	if m == nil {
+		return
+	}
+	m.apiErrors.Inc()
+}
+
+// RecordRun keeps the result of each folder of a run for /status.
+func (m *metrics) RecordRun(report *runReport) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRun = now
+	for _, r := range report.Results {
+		s := folderStatusJSON{
+			Folder:  r.Folder.Root,
+			ID:      r.Folder.ID,
+			Status:  r.Status,
+			Added:   r.Added,
+			Removed: r.Removed,
+			Time:    now,
+		}
+		if r.Err != nil {
+			s.Error = r.Err.Error()
+		}
+		m.folders[folderLabel(r.Folder)] = s
+	}
+	if report.Err() == nil {
+		m.lastSuccessTime = now
+		m.lastSuccess.Set(float64(now.Unix()))
+	}
+}
+
+// Handler serves /metrics and /status.
+func (m *metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", m.serveStatus)
+	return mux
+}
+
+func (m *metrics) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	status := struct {
+		LastRun     *time.Time         `json:"last_run,omitempty"`
+		LastSuccess *time.Time         `json:"last_success,omitempty"`
+		Folders     []folderStatusJSON `json:"folders"`
+	}{Folders: make([]folderStatusJSON, 0, len(m.folders))}
+	if !m.lastRun.IsZero() {
+		status.LastRun = &m.lastRun
+	}
+	if !m.lastSuccessTime.IsZero() {
+		status.LastSuccess = &m.lastSuccessTime
+	}
+	for _, s := range m.folders {
+		status.Folders = append(status.Folders, s)
+	}
+	slices.SortFunc(status.Folders, func(a, b folderStatusJSON) int { return strings.Compare(a.Folder, b.Folder) })
+	content, err := json.MarshalIndent(status, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(content)
+}
+
+// Serve serves the metrics on ln until ctx is done. The listener is
+// created by the caller so failing to bind fails the command.
+func (m *metrics) Serve(ctx context.Context, ln net.Listener) error {
+	server := &http.Server{Handler: m.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Serve(ln)
+	}()
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("failed to serve metrics on %s: %w", ln.Addr(), err)
+	case <-ctx.Done():
+	}
+	err := server.Shutdown(context.Background())
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to stop metrics server: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := newMetrics()
+	ok := syncFolder{ID: "code", Root: "/data/code"}
+	failed := syncFolder{Root: "/data/broken"}
+	m.ObservePlan(&folderPlan{
+		folder:   ok,
+		scanTime: 50 * time.Millisecond,
+		stats:    scanStats{Dirs: 12, Patterns: map[string]int{"rust": 2}},
+	})
+	m.WriteFailed(failed)
+	m.APIError()
+	report := &runReport{}
+	report.Add(folderResult{Folder: ok, Status: statusUpdated, Added: 2})
+	report.Add(folderResult{Folder: failed, Status: statusFailed, Err: errors.New("permission denied")})
+	m.RecordRun(report)
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	get := func(path string) string {
+		resp, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	body := get("/metrics")
+	for _, expected := range []string{
+		`particle_patterns{folder="code",rule="rust"} 2`,
+		`particle_scan_directories_total{folder="code"} 12`,
+		`particle_write_failures_total{folder="/data/broken"} 1`,
+		`particle_syncthing_api_errors_total 1`,
+		`particle_scan_duration_seconds_count{folder="code"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in metrics, got:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "particle_last_success_timestamp_seconds 1") {
+		t.Errorf("Expected no successful run, got:\n%s", body)
+	}
+
+	var status struct {
+		LastSuccess *time.Time         `json:"last_success"`
+		Folders     []folderStatusJSON `json:"folders"`
+	}
+	if err := json.Unmarshal([]byte(get("/status")), &status); err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+	if status.LastSuccess != nil {
+		t.Errorf("Expected no last success, got %v", status.LastSuccess)
+	}
+	if len(status.Folders) != 2 || status.Folders[0].Error != "permission denied" || status.Folders[1].Added != 2 {
+		t.Errorf("Unexpected folders in status: %+v", status.Folders)
+	}
+}
+
+func TestWatchMetricsListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err = os.WriteFile(configPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	done := make(chan int, 1)
+	go func() {
+		done <- run([]string{"watch", "-config", configPath, "-metrics", "-listen", ln.Addr().String(), t.TempDir()})
+	}()
+	select {
+	case got := <-done:
+		if got != exitFailure {
+			t.Errorf("Unexpected exit code. Got %d, expected %d", got, exitFailure)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected watch to fail when the metrics address is in use")
+	}
+}
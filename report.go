@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes of particle.
+const (
+	exitOK = 0
+	// every folder failed, or particle could not start at all
+	exitFailure = 1
+	exitUsage   = 2
+	// some folders failed, the others were processed
+	exitPartialFailure = 3
+)
+
+var (
+	errTotalFailure   = errors.New("all folders failed")
+	errPartialFailure = errors.New("some folders failed")
+)
+
+type folderStatus string
+
+const (
+	statusUpdated   folderStatus = "updated"
+	statusUnchanged folderStatus = "unchanged"
+	statusFailed    folderStatus = "failed"
+)
+
+// folderResult is the outcome of processing one folder.
+type folderResult struct {
+	Folder  syncFolder
+	Status  folderStatus
+	Added   int
+	Removed int
+	Err     error
+}
+
+// runReport collects the per-folder results of one run.
+type runReport struct {
+	Results []folderResult
+}
+
+func (r *runReport) Add(result folderResult) {
+	r.Results = append(r.Results, result)
+}
+
+func (r *runReport) Fail(f syncFolder, err error) {
+	logger.Errorf("folder %s: %v", f, err)
+	r.Add(folderResult{Folder: f, Status: statusFailed, Err: err})
+}
+
+func (r *runReport) Count(status folderStatus) int {
+	var n int
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns errTotalFailure if every folder failed, errPartialFailure
+// if some did, nil otherwise.
+func (r *runReport) Err() error {
+	failed := r.Count(statusFailed)
+	switch {
+	case failed == 0:
+		return nil
+	case failed == len(r.Results):
+		return fmt.Errorf("%w (%d)", errTotalFailure, failed)
+	default:
+		return fmt.Errorf("%w (%d of %d)", errPartialFailure, failed, len(r.Results))
+	}
+}
+
+// Print writes the summary table.
+func (r *runReport) Print(w io.Writer) {
+	results := slices.Clone(r.Results)
+	slices.SortStableFunc(results, func(a, b folderResult) int {
+		return strings.Compare(a.Folder.Root, b.Folder.Root)
+	})
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FOLDER\tSTATUS\tDETAIL")
+	for _, result := range results {
+		var detail string
+		switch result.Status {
+		case statusFailed:
+			detail = result.Err.Error()
+		case statusUpdated:
+			detail = fmt.Sprintf("+%d -%d", result.Added, result.Removed)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Folder, result.Status, detail)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "%d updated, %d unchanged, %d failed\n",
+		r.Count(statusUpdated), r.Count(statusUnchanged), r.Count(statusFailed))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunReportErr(t *testing.T) {
+	ok := folderResult{Folder: syncFolder{Root: "/ok"}, Status: statusUpdated}
+	unchanged := folderResult{Folder: syncFolder{Root: "/same"}, Status: statusUnchanged}
+	failed := folderResult{Folder: syncFolder{Root: "/bad"}, Status: statusFailed, Err: errors.New("boom")}
+	tests := []struct {
+		name    string
+		results []folderResult
+		want    error
+	}{
+		{"empty", nil, nil},
+		{"all succeeded", []folderResult{ok, unchanged}, nil},
+		{"partial failure", []folderResult{ok, failed}, errPartialFailure},
+		{"total failure", []folderResult{failed, failed}, errTotalFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&runReport{Results: tt.results}).Err()
+			if tt.want == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Unexpected error. Got %v, expected %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	good := func() string {
+		return makeTree(t, "Cargo.toml", "Cargo.lock", "target/")
+	}
+	// three separator lines make .stignore unreadable for particle
+	corrupt := func() string {
+		root := t.TempDir()
+		content := strings.Repeat(ParticleSeparatorLine+"\n", 3)
+		if err := os.WriteFile(filepath.Join(root, ".stignore"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write .stignore: %v", err)
+		}
+		return root
+	}
+	tests := []struct {
+		name string
+		dirs []string
+		want int
+	}{
+		{"all succeeded", []string{good(), good()}, exitOK},
+		{"partial failure", []string{good(), corrupt()}, exitPartialFailure},
+		{"total failure", []string{corrupt(), corrupt()}, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"apply", "-config", configPath}, tt.dirs...)
+			if got := run(args); got != tt.want {
+				t.Errorf("Unexpected exit code. Got %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// isTerminal reports whether f is a terminal, review needs one.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reviewPlan asks about each line added to or removed from the particle
+// lines of p. Rejected additions are dropped from the plan and returned
+// to be suppressed, rejected removals are kept for this run.
+func reviewPlan(in *bufio.Reader, out io.Writer, p *folderPlan, rules []IgnoreRule) (suppressed []string, err error) {
+	added, removed := p.Changes()
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	fmt.Fprintf(out, "# %s\n", p.folder)
+	var kept []string
+	for _, line := range added {
+		ok, err := askLine(in, out, "+", p.folder.Root, line, rules)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			suppressed = append(suppressed, line)
+		}
+	}
+	for _, line := range removed {
+		ok, err := askLine(in, out, "-", p.folder.Root, line, rules)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			kept = append(kept, line)
+		}
+	}
+	if len(suppressed) == 0 && len(kept) == 0 {
+		return nil, nil
+	}
+	lines := slices.DeleteFunc(slices.Clone(p.proposed), func(line string) bool {
+		return slices.Contains(suppressed, line)
+	})
+	p.stIgnore.OverwriteIgnores(append(lines, kept...))
+	p.proposed = p.stIgnore.ParticleLines()
+	return suppressed, nil
+}
+
+// askLine prints a changed line with the rules generating it and the
+// size of what it matches, then asks whether to accept the change.
+func askLine(in *bufio.Reader, out io.Writer, sign, root, line string, rules []IgnoreRule) (bool, error) {
+	_, origins := particleOrigins(root, line, rules)
+	names := "no rule"
+	if len(origins) > 0 {
+		var ruleNames []string
+		for _, o := range origins {
+			ruleNames = append(ruleNames, o.Rule.Name)
+		}
+		names = strings.Join(ruleNames, ", ")
+	}
+	size := "size unknown"
+	if n, ok := matchedSize(root, line); ok {
+		size = formatSize(n)
+	}
+	fmt.Fprintf(out, "%s%s  (%s, %s)\naccept? [Y/n] ", sign, line, names, size)
+	answer, err := in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer != "n" && answer != "no", nil
+}
+
+// matchedSize returns the total size of the files matched by an anchored
+// line, ok is false for unanchored lines.
+func matchedSize(root, line string) (size int64, ok bool) {
+	pattern, _ := splitNegation(line)
+	pattern = strings.ReplaceAll(strings.ReplaceAll(pattern, "(?d)", ""), "(?i)", "")
+	if !strings.HasPrefix(pattern, "/") {
+		return 0, false
+	}
+	matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(pattern)))
+	if err != nil {
+		return 0, false
+	}
+	for _, match := range matches {
+		_ = filepath.WalkDir(match, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size, true
+}
+
+// formatSize formats a size in bytes with binary units, e.g. 1.5 MiB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReviewPlan(t *testing.T) {
+	root := makeTree(t, "a/Cargo.toml", "a/Cargo.lock", "a/target/", "b/Cargo.toml", "b/Cargo.lock", "b/target/")
+	err := os.WriteFile(filepath.Join(root, "a", "target", "out.bin"), make([]byte, 2048), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	block := ParticleSeparatorLine + "\n(?d)/gone\n" + ParticleSeparatorLine + "\n"
+	err = os.WriteFile(filepath.Join(root, ".stignore"), []byte(block), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write .stignore: %v", err)
+	}
+	p, err := newApp(defaultConfig()).Plan(syncFolder{Root: root})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	rust, _ := findRule("rust")
+
+	var out bytes.Buffer
+	// accept /a/target, reject /b/target and the removal of /gone
+	in := bufio.NewReader(strings.NewReader("\nn\nn\n"))
+	suppressed, err := reviewPlan(in, &out, p, []IgnoreRule{rust})
+	if err != nil {
+		t.Fatalf("Failed to review: %v", err)
+	}
+	if expected := []string{"(?d)/b/target"}; !reflect.DeepEqual(suppressed, expected) {
+		t.Errorf("Unexpected suppressed lines. Got %v, expected %v", suppressed, expected)
+	}
+	if expected := []string{"(?d)/a/target", "(?d)/gone"}; !reflect.DeepEqual(p.proposed, expected) {
+		t.Errorf("Unexpected particle lines. Got %v, expected %v", p.proposed, expected)
+	}
+	if !strings.Contains(out.String(), "+(?d)/a/target  (rust, 2.0 KiB)") {
+		t.Errorf("Expected rule and size in output, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "-(?d)/gone  (no rule, 0 B)") {
+		t.Errorf("Expected removed line in output, got %q", out.String())
+	}
+}
@@ -2,85 +2,838 @@ package main
 
 import (
 	"os"
+	"path"
+	"path/filepath"
 	"slices"
 	"strings"
 )
 
+// StIgnoreCheckFunc returns the names to ignore in dir, relative to dir.
+// Use fromNames to turn it into a RuleFunc.
+type StIgnoreCheckFunc = func(dir string, entry []os.DirEntry) []string
+
+// RuleFunc returns the patterns to write for dir.
+type RuleFunc = func(dir string, entry []os.DirEntry) []Ignore
+
+// Ignore is a pattern generated by a rule for a directory.
+type Ignore struct {
+	// Relative to the scanned directory, may be nested or a glob
+	Name string
+	// Write a '!' line keeping Name, for exceptions to other patterns
+	Negate bool
+	// Add the '(?i)' prefix
+	CaseInsensitive bool
+	// Add the '(?d)' prefix so Syncthing may delete it to remove a
+	// parent directory, unless turned off with -removeD
+	Deletable bool
+	// Keep scanning inside Name, e.g. to find exceptions
+	Descend bool
+	// Patterns with a higher priority are written first
+	Priority int
+
+	// name of the rule generating it, set by the scanner for stats
+	rule string
+}
+
+// Line returns the .stignore line of i, anchored under parentsDir.
+func (i Ignore) Line(parentsDir string, removeD bool) string {
+	return i.prefix(removeD) + parentsDir + "/" + i.Name
+}
+
+// GlobalLine returns the unanchored .stignore line of i, matching at any
+// depth.
+func (i Ignore) GlobalLine(removeD bool) string {
+	return i.prefix(removeD) + i.Name
+}
+
+// prefix returns the '!', '(?i)' and '(?d)' prefixes of the line of i.
+// withoutDeletable returns line without its (?d) prefix, lines only
+// differing by it are the same pattern.
+func withoutDeletable(line string) string {
+	return strings.Replace(line, "(?d)", "", 1)
+}
+
+func (i Ignore) prefix(removeD bool) string {
+	var prefix string
+	if i.Negate {
+		prefix = "!"
+	}
+	if i.CaseInsensitive {
+		prefix += "(?i)"
+	}
+	if i.Deletable && !removeD && !i.Negate {
+		prefix += "(?d)"
+	}
+	return prefix
+}
+
+// fromNames adapts a checker returning names, they are all deletable.
+func fromNames(check StIgnoreCheckFunc) RuleFunc {
+	return func(dir string, entry []os.DirEntry) []Ignore {
+		names := check(dir, entry)
+		if len(names) == 0 {
+			return nil
+		}
+		ignores := make([]Ignore, 0, len(names))
+		for _, name := range names {
+			ignores = append(ignores, Ignore{Name: name, Deletable: true})
+		}
+		return ignores
+	}
+}
+
+// dirIgnores returns deletable dir patterns for names.
+func dirIgnores(names ...string) []Ignore {
+	ignores := make([]Ignore, 0, len(names))
+	for _, name := range names {
+		ignores = append(ignores, Ignore{Name: name, Deletable: true})
+	}
+	return ignores
+}
+
+// markerIgnores returns a RuleFunc ignoring outputs in the directories
+// containing any of markers, whether the outputs exist or not.
+func markerIgnores(markers []string, outputs ...string) RuleFunc {
+	return func(_ string, entry []os.DirEntry) []Ignore {
+		if !hasEntryMatching(entry, markers...) {
+			return nil
+		}
+		return dirIgnores(outputs...)
+	}
+}
+
 // Detect some files or folders and ignore some files or folders
-// type IgnoreRule struct {
-// 	checkList func([]os.DirEntry) []string
-// }
+type IgnoreRule struct {
+	Name        string
+	Description string
+	// Bumped when the patterns generated by the rule change
+	Version int
+	// Files whose presence makes the rule match, may be globs.
+	// Only used to explain the generated ignores.
+	Markers []string
+	// The rule is disabled unless enabled in the config
+	OptIn bool
+	// Its patterns get the '(?d)' prefix, so Syncthing may delete them
+	// to remove a parent directory deleted on another device
+	Deletable bool
+	// Unanchored patterns written once at the top of the particle block,
+	// they match at any depth.
+	Global []string
+	// May be nil for rules with only global patterns
+	Detect RuleFunc
+	// Patterns from the project markers alone, written in proactive mode
+	// so outputs are ignored before the first build. May be nil.
+	Proactive RuleFunc
+}
 
-type StIgnoreCheckFunc = func(dir string, entry []os.DirEntry) []string
+// Ignores runs the rule on dir.
+func (r IgnoreRule) Ignores(dir string, entry []os.DirEntry) []Ignore {
+	if r.Detect == nil {
+		return nil
+	}
+	return r.Detect(dir, entry)
+}
+
+// ProactiveIgnores runs the rule on dir, adding the patterns of Proactive
+// not generated already.
+func (r IgnoreRule) ProactiveIgnores(dir string, entry []os.DirEntry) []Ignore {
+	ignores := r.Ignores(dir, entry)
+	if r.Proactive == nil {
+		return ignores
+	}
+	for _, i := range r.Proactive(dir, entry) {
+		if !slices.ContainsFunc(ignores, func(o Ignore) bool { return o.Name == i.Name }) {
+			ignores = append(ignores, i)
+		}
+	}
+	return ignores
+}
+
+var StIgnoreRules = []IgnoreRule{
+	{
+		Name:        "rust",
+		Description: "Rust target",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Cargo.toml", "Cargo.lock"},
+		Detect:      RustProjectStIgnoreChecker,
+		Proactive:   markerIgnores([]string{"Cargo.toml"}, "target"),
+	},
+	{
+		Name:        "nodejs",
+		Description: "Node.js node_modules and dist",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"package.json", "node_modules"},
+		Detect:      NodejsProjectStIgnoreChecker,
+		Proactive:   markerIgnores([]string{"package.json"}, "node_modules"),
+	},
+	{
+		Name:        "dart",
+		Description: "Dart/Flutter build, .dart_tool and ios/Pods",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"pubspec.yaml", "pubspec.lock"},
+		Detect:      DartProjectStIgnoreChecker,
+		Proactive:   markerIgnores([]string{"pubspec.yaml"}, "build", ".dart_tool"),
+	},
+	{
+		Name:        "conda",
+		Description: "Python .conda environments",
+		Version:     2,
+		Markers:     []string{".conda*"},
+		Detect:      PythonCondaStIgnoreChecker,
+	},
+	{
+		Name:        "android",
+		Description: "Android/Gradle build",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"build.gradle", "build.gradle.kts"},
+		Detect:      AndroidProjectStIgnoreChecker,
+	},
+	{
+		Name:        "maven",
+		Description: "Maven target",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"pom.xml"},
+		Detect:      fromNames(MavenProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"pom.xml"}, "target"),
+	},
+	{
+		Name:        "gradle",
+		Description: "Gradle caches and build outputs",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts", "gradlew"},
+		Detect:      fromNames(GradleProjectStIgnoreChecker),
+	},
+	{
+		Name:        "sbt",
+		Description: "sbt target and Scala tooling (.bsp, .bloop, .metals)",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"build.sbt"},
+		Detect:      fromNames(SbtProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"build.sbt"}, "target"),
+	},
+	{
+		Name:        "clojure",
+		Description: "Leiningen target, .cpcache and .shadow-cljs",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"project.clj", "deps.edn", "shadow-cljs.edn"},
+		Detect:      fromNames(ClojureProjectStIgnoreChecker),
+	},
+	{
+		Name:        "bazel",
+		Description: "Bazel bazel-* output symlinks",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel"},
+		Detect:      fromNames(BazelProjectStIgnoreChecker),
+	},
+	{
+		Name:        "python-venv",
+		Description: "Python virtual environments (pyvenv.cfg, .venv)",
+		Version:     2,
+		Markers:     []string{"*/pyvenv.cfg", "pyproject.toml", "poetry.lock", "pdm.lock", "uv.lock"},
+		Detect:      fromNames(PythonVenvStIgnoreChecker),
+	},
+	{
+		Name:        "python-cache",
+		Description: "__pycache__, pytest, mypy, ruff and Jupyter caches",
+		Version:     1,
+		Deletable:   true,
+		Detect:      fromNames(PythonCacheStIgnoreChecker),
+	},
+	{
+		Name:        "python-build",
+		Description: "Python build, dist, *.egg-info, .tox and .nox",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"pyproject.toml", "setup.py", "setup.cfg", "tox.ini", "noxfile.py"},
+		Detect:      fromNames(PythonBuildStIgnoreChecker),
+	},
+	{
+		Name:        "dotnet",
+		Description: ".NET bin, obj and .vs",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.csproj", "*.fsproj", "*.vbproj", "*.sln", "*.slnx"},
+		Detect:      fromNames(DotnetProjectStIgnoreChecker),
+	},
+	{
+		Name:        "cmake",
+		Description: "CMake build directories (containing CMakeCache.txt)",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"CMakeLists.txt", "*/CMakeCache.txt"},
+		Detect:      fromNames(CMakeProjectStIgnoreChecker),
+	},
+	{
+		Name:        "meson",
+		Description: "Meson build directories (containing meson-private)",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"meson.build", "*/meson-private"},
+		Detect:      fromNames(MesonProjectStIgnoreChecker),
+	},
+	{
+		Name:        "autotools",
+		Description: "Autotools generated files and objects",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"configure.ac", "configure.in", ".deps", ".libs"},
+		Detect:      fromNames(AutotoolsProjectStIgnoreChecker),
+	},
+	{
+		Name:        "vcpkg",
+		Description: "vcpkg manifest mode vcpkg_installed",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"vcpkg.json"},
+		Detect:      fromNames(VcpkgProjectStIgnoreChecker),
+	},
+	{
+		Name:        "conan",
+		Description: "Conan build folders",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"conanfile.txt", "conanfile.py"},
+		Detect:      fromNames(ConanProjectStIgnoreChecker),
+	},
+	{
+		Name:        "go-vendor",
+		Description: "Go vendor directory",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"go.mod", "vendor/modules.txt"},
+		OptIn:       true,
+		Detect:      fromNames(GoVendorStIgnoreChecker),
+	},
+	{
+		Name:        "go-bin",
+		Description: "Go bin built by a Makefile",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"go.mod", "Makefile", "makefile", "GNUmakefile"},
+		OptIn:       true,
+		Detect:      fromNames(GoBinStIgnoreChecker),
+	},
+	{
+		Name:        "zig",
+		Description: "Zig zig-cache, .zig-cache and zig-out",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"build.zig"},
+		Detect:      fromNames(ZigProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"build.zig"}, ".zig-cache", "zig-out"),
+	},
+	{
+		Name:        "haskell",
+		Description: "Haskell .stack-work and dist-newstyle",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"stack.yaml", "cabal.project", "*.cabal"},
+		Detect:      fromNames(HaskellProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"stack.yaml"}, ".stack-work"),
+	},
+	{
+		Name:        "ocaml",
+		Description: "OCaml dune _build and _opam",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"dune-project"},
+		Detect:      fromNames(OCamlProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"dune-project"}, "_build"),
+	},
+	{
+		Name:        "elixir",
+		Description: "Elixir _build, deps and .elixir_ls",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"mix.exs"},
+		Detect:      fromNames(ElixirProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"mix.exs"}, "_build", "deps"),
+	},
+	{
+		Name:        "erlang",
+		Description: "Erlang rebar3 _build",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"rebar.config"},
+		Detect:      fromNames(ErlangProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"rebar.config"}, "_build"),
+	},
+	{
+		Name:        "nextjs",
+		Description: "Next.js .next and out",
+		Version:     1,
+		Deletable:   true,
+		Markers:     nextjsMarkers,
+		Detect:      fromNames(NextjsProjectStIgnoreChecker),
+		Proactive:   markerIgnores(nextjsMarkers, ".next"),
+	},
+	{
+		Name:        "nuxt",
+		Description: "Nuxt .nuxt and .output",
+		Version:     1,
+		Deletable:   true,
+		Markers:     nuxtMarkers,
+		Detect:      fromNames(NuxtProjectStIgnoreChecker),
+		Proactive:   markerIgnores(nuxtMarkers, ".nuxt", ".output"),
+	},
+	{
+		Name:        "sveltekit",
+		Description: "SvelteKit .svelte-kit",
+		Version:     1,
+		Deletable:   true,
+		Markers:     svelteKitMarkers,
+		Detect:      fromNames(SvelteKitProjectStIgnoreChecker),
+		Proactive:   markerIgnores(svelteKitMarkers, ".svelte-kit"),
+	},
+	{
+		Name:        "angular",
+		Description: "Angular .angular cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     angularMarkers,
+		Detect:      fromNames(AngularProjectStIgnoreChecker),
+		Proactive:   markerIgnores(angularMarkers, ".angular"),
+	},
+	{
+		Name:        "vite",
+		Description: "Vite dist and node_modules/.vite cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     viteMarkers,
+		Detect:      fromNames(ViteProjectStIgnoreChecker),
+	},
+	{
+		Name:        "parcel",
+		Description: "Parcel .parcel-cache and dist",
+		Version:     1,
+		Deletable:   true,
+		Markers:     parcelMarkers,
+		Detect:      fromNames(ParcelProjectStIgnoreChecker),
+		Proactive:   markerIgnores(parcelMarkers, ".parcel-cache"),
+	},
+	{
+		Name:        "turbo",
+		Description: "Turborepo .turbo cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     append([]string{"package.json"}, turboMarkers...),
+		Detect:      fromNames(TurboProjectStIgnoreChecker),
+		Proactive:   markerIgnores(turboMarkers, ".turbo"),
+	},
+	{
+		Name:        "nx",
+		Description: "Nx .nx/cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     nxMarkers,
+		Detect:      fromNames(NxProjectStIgnoreChecker),
+	},
+	{
+		Name:        "yarn-berry",
+		Description: "Yarn Berry .yarn/cache (unless zero-installs) and install state",
+		Version:     1,
+		Deletable:   true,
+		Markers:     yarnBerryMarkers,
+		Detect:      fromNames(YarnBerryProjectStIgnoreChecker),
+	},
+	{
+		Name:        "pnpm",
+		Description: "pnpm project local .pnpm-store and symlinked node_modules",
+		Version:     2,
+		Deletable:   true,
+		Markers:     pnpmMarkers,
+		Detect:      fromNames(PnpmProjectStIgnoreChecker),
+	},
+	{
+		Name:        "deno",
+		Description: "Deno node_modules and vendor",
+		Version:     1,
+		Deletable:   true,
+		Markers:     denoMarkers,
+		Detect:      fromNames(DenoProjectStIgnoreChecker),
+	},
+	{
+		Name:        "bun",
+		Description: "Bun node_modules",
+		Version:     1,
+		Deletable:   true,
+		Markers:     bunMarkers,
+		Detect:      fromNames(BunProjectStIgnoreChecker),
+	},
+	{
+		Name:        "storybook",
+		Description: "Storybook storybook-static",
+		Version:     1,
+		Deletable:   true,
+		Markers:     storybookMarkers,
+		Detect:      fromNames(StorybookProjectStIgnoreChecker),
+	},
+	{
+		Name:        "unity",
+		Description: "Unity Library, Temp, Obj, Logs, UserSettings and Build*",
+		Version:     2,
+		Markers:     []string{"ProjectSettings/ProjectVersion.txt"},
+		Detect:      fromNames(UnityProjectStIgnoreChecker),
+	},
+	{
+		Name:        "unreal",
+		Description: "Unreal Binaries, Intermediate, Saved and DerivedDataCache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.uproject"},
+		Detect:      fromNames(UnrealProjectStIgnoreChecker),
+	},
+	{
+		Name:        "godot",
+		Description: "Godot .godot and .import",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"project.godot"},
+		Detect:      fromNames(GodotProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"project.godot"}, ".godot"),
+	},
+	{
+		Name:        "xcode",
+		Description: "Xcode xcuserdata and DerivedData",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.xcodeproj", "*.xcworkspace"},
+		Detect:      fromNames(XcodeProjectStIgnoreChecker),
+	},
+	{
+		Name:        "cocoapods",
+		Description: "CocoaPods Pods",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Podfile"},
+		Detect:      fromNames(CocoaPodsProjectStIgnoreChecker),
+	},
+	{
+		Name:        "swiftpm",
+		Description: "Swift Package Manager .build",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Package.swift"},
+		Detect:      fromNames(SwiftPMProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"Package.swift"}, ".build"),
+	},
+	{
+		Name:        "terraform",
+		Description: "Terraform .terraform and Terragrunt .terragrunt-cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.tf", "terragrunt.hcl"},
+		Detect:      fromNames(TerraformProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"*.tf"}, ".terraform"),
+	},
+	{
+		Name:        "pulumi",
+		Description: "Pulumi TypeScript bin",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Pulumi.yaml", "tsconfig.json"},
+		Detect:      fromNames(PulumiProjectStIgnoreChecker),
+	},
+	{
+		Name:        "vagrant",
+		Description: "Vagrant .vagrant",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Vagrantfile"},
+		Detect:      fromNames(VagrantProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"Vagrantfile"}, ".vagrant"),
+	},
+	{
+		Name:        "serverless",
+		Description: "Serverless Framework .serverless",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"serverless.yml", "serverless.yaml", "serverless.ts"},
+		Detect:      fromNames(ServerlessProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"serverless.yml", "serverless.yaml", "serverless.ts"}, ".serverless"),
+	},
+	{
+		Name:        "latex",
+		Description: "LaTeX auxiliary files (*.aux, *.log, *.synctex.gz, _minted-*, ...)",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.tex"},
+		Detect:      fromNames(LatexProjectStIgnoreChecker),
+	},
+	{
+		Name:        "hugo",
+		Description: "Hugo resources/_gen and public",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"hugo.toml", "hugo.yaml", "config.toml"},
+		Detect:      fromNames(HugoProjectStIgnoreChecker),
+	},
+	{
+		Name:        "jekyll",
+		Description: "Jekyll _site and .jekyll-cache",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"_config.yml"},
+		Detect:      fromNames(JekyllProjectStIgnoreChecker),
+		Proactive:   markerIgnores([]string{"_config.yml"}, "_site", ".jekyll-cache"),
+	},
+	{
+		Name:        "docs-build",
+		Description: "MkDocs site and Sphinx _build",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"mkdocs.yml", "conf.py", "source/conf.py"},
+		Detect:      fromNames(DocsBuildStIgnoreChecker),
+	},
+	{
+		Name:        "dvc",
+		Description: "DVC .dvc/cache and .dvc/tmp",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{".dvc"},
+		Detect:      fromNames(DvcProjectStIgnoreChecker),
+	},
+	{
+		Name:        "r",
+		Description: "R .Rproj.user and renv library",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"*.Rproj", "renv.lock"},
+		Detect:      fromNames(RProjectStIgnoreChecker),
+	},
+	{
+		Name:        "julia",
+		Description: "Julia deps build artifacts and Documenter build",
+		Version:     1,
+		Deletable:   true,
+		Markers:     []string{"Project.toml"},
+		Detect:      fromNames(JuliaProjectStIgnoreChecker),
+	},
+	{
+		Name:        "junk",
+		Description: "OS and editor junk files (.DS_Store, Thumbs.db, *.swp, ...)",
+		Version:     1,
+		Deletable:   true,
+		OptIn:       true,
+		Global:      junkPatterns,
+	},
+}
+
+func findRule(name string) (IgnoreRule, bool) {
+	for _, r := range StIgnoreRules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return IgnoreRule{}, false
+}
 
-var StIgnoreCheckList = []StIgnoreCheckFunc{
-	RustProjectStIgnoreChecker,
-	NodejsProjectStIgnoreChecker,
-	DartProjectStIgnoreChecker,
-	PythonCondaStIgnoreChecker,
-	AndroidProjectStIgnoreChecker,
+// FoundMarkers returns the marker files of the rule present in dir.
+// A marker containing '/' is matched against nested paths.
+func (r IgnoreRule) FoundMarkers(dir string, entries []os.DirEntry) []string {
+	var found []string
+	for _, entry := range entries {
+		for _, marker := range r.Markers {
+			if ok, _ := path.Match(marker, entry.Name()); ok {
+				found = append(found, entry.Name())
+				break
+			}
+		}
+	}
+	for _, marker := range r.Markers {
+		if !strings.Contains(marker, "/") {
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(marker)))
+		for _, m := range matches {
+			if rel, err := filepath.Rel(dir, m); err == nil {
+				found = append(found, filepath.ToSlash(rel))
+			}
+		}
+	}
+	return found
 }
 
 // Ignore Rust build files
 // If it contains Cargo.toml and Cargo.lock, it is considered a Rust project
-var RustProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+var RustProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []Ignore {
 	var filenames = make([]string, 0)
 	for _, v := range entry {
 		filenames = append(filenames, v.Name())
 	}
 	if slices.Contains(filenames, "Cargo.toml") && slices.Contains(filenames, "Cargo.lock") {
-		return []string{"target"}
+		return dirIgnores("target")
 	}
 	return nil
 }
 
 // Ignore Node.js project
 // If it contains package.json and node_modules, it is considered a Node.js project
-var NodejsProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+var NodejsProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []Ignore {
 	var filenames = make([]string, 0)
 	for _, v := range entry {
 		filenames = append(filenames, v.Name())
 	}
 	if slices.Contains(filenames, "package.json") && slices.Contains(filenames, "node_modules") {
-		return []string{"node_modules", "dist"}
+		return dirIgnores("node_modules", "dist")
 	}
 	return nil
 }
 
 // Ignore Flutter project
 // If it contains pubspec.yaml and pubspec.lock, it is considered a Flutter project
-var DartProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+var DartProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []Ignore {
 	var filenames = make([]string, 0)
 	for _, v := range entry {
 		filenames = append(filenames, v.Name())
 	}
 	if slices.Contains(filenames, "pubspec.yaml") && slices.Contains(filenames, "pubspec.lock") {
-		return []string{"build"}
+		return dirIgnores(append([]string{"build"}, existingEntries(dir, entry, ".dart_tool", "ios/Pods", "macos/Pods")...)...)
 	}
 	return nil
 }
 
 // Ignore Python .conda
-var PythonCondaStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+var PythonCondaStIgnoreChecker = func(_ string, entry []os.DirEntry) []Ignore {
 	var filenames = make([]string, 0)
 	for _, v := range entry {
 		filenames = append(filenames, v.Name())
 	}
 	for _, v := range filenames {
 		if strings.HasPrefix(v, ".conda") {
-			return []string{".conda"}
+			return dirIgnores(".conda")
 		}
 	}
 	return nil
 }
 
-var AndroidProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+// Ignore Android project
+// If it contains build.gradle or build.gradle.kts, it is considered an Android project
+var AndroidProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []Ignore {
 	var filenames = make([]string, 0)
 	for _, v := range entry {
 		filenames = append(filenames, v.Name())
 	}
 	if slices.Contains(filenames, "build.gradle") || slices.Contains(filenames, "build.gradle.kts") {
-		return []string{"build"}
+		return dirIgnores("build")
 	}
 	return nil
 }
+
+func entryNames(entry []os.DirEntry) []string {
+	var filenames = make([]string, 0, len(entry))
+	for _, v := range entry {
+		filenames = append(filenames, v.Name())
+	}
+	return filenames
+}
+
+// containsAny reports whether any of names is in filenames.
+func containsAny(filenames []string, names ...string) bool {
+	for _, name := range names {
+		if slices.Contains(filenames, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// existingEntries returns the names present in dir, a name may be a
+// nested path such as "project/target".
+func existingEntries(dir string, entry []os.DirEntry, names ...string) []string {
+	filenames := entryNames(entry)
+	var found []string
+	for _, name := range names {
+		if !strings.Contains(name, "/") {
+			if slices.Contains(filenames, name) {
+				found = append(found, name)
+			}
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// dirContainsAny reports whether the directory dir/name contains any of names.
+func dirContainsAny(dir string, name string, names ...string) bool {
+	entries, err := os.ReadDir(filepath.Join(dir, name))
+	if err != nil {
+		return false
+	}
+	return containsAny(entryNames(entries), names...)
+}
+
+// childDirsContaining returns the child directories of dir that contain
+// any of names, a name may be a nested path or a glob.
+func childDirsContaining(dir string, entry []os.DirEntry, names ...string) []string {
+	var found []string
+	for _, v := range entry {
+		if !v.IsDir() {
+			continue
+		}
+		for _, name := range names {
+			p := filepath.Join(dir, v.Name(), filepath.FromSlash(name))
+			var exists bool
+			if strings.ContainsAny(name, "*?[") {
+				matches, _ := filepath.Glob(p)
+				exists = len(matches) > 0
+			} else {
+				_, err := os.Stat(p)
+				exists = err == nil
+			}
+			if exists {
+				found = append(found, v.Name())
+				break
+			}
+		}
+	}
+	return found
+}
+
+// hasEntryMatching reports whether any entry name matches one of the globs.
+func hasEntryMatching(entry []os.DirEntry, patterns ...string) bool {
+	for _, v := range entry {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, v.Name()); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// symlinkEntries returns the names of the links matching one of the globs,
+// junctions included (see isLink), so the links themselves get ignored.
+func symlinkEntries(entry []os.DirEntry, patterns ...string) []string {
+	var found []string
+	for _, v := range entry {
+		if isLink(v) && hasEntryMatching([]os.DirEntry{v}, patterns...) {
+			found = append(found, v.Name())
+		}
+	}
+	return found
+}
+
+// matchingPatterns returns the globs of patterns that match at least one
+// entry, rules emit them as file patterns.
+func matchingPatterns(entry []os.DirEntry, patterns ...string) []string {
+	var found []string
+	for _, pattern := range patterns {
+		if hasEntryMatching(entry, pattern) {
+			found = append(found, pattern)
+		}
+	}
+	return found
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+)
+
+// Ignore Xcode user data and build files
+// xcuserdata inside *.xcodeproj and *.xcworkspace, and a project local
+// DerivedData.
+var XcodeProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, "*.xcodeproj", "*.xcworkspace") {
+		return nil
+	}
+	ignores := existingEntries(dir, entry, "DerivedData")
+	for _, v := range entry {
+		if v.IsDir() && hasEntryMatching([]os.DirEntry{v}, "*.xcodeproj", "*.xcworkspace") {
+			ignores = append(ignores, existingEntries(dir, entry, v.Name()+"/xcuserdata")...)
+		}
+	}
+	return ignores
+}
+
+// Ignore CocoaPods installed pods
+// If it contains a Podfile, Pods is ignored.
+var CocoaPodsProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "Podfile") {
+		return nil
+	}
+	return existingEntries(dir, entry, "Pods")
+}
+
+// Ignore Swift Package Manager build files
+// If it contains Package.swift, .build is ignored.
+var SwiftPMProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "Package.swift") {
+		return nil
+	}
+	return existingEntries(dir, entry, ".build")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+)
+
+// Ignore DVC caches
+// The cache and tmp directories of a .dvc directory.
+var DvcProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), ".dvc") {
+		return nil
+	}
+	return existingEntries(dir, entry, ".dvc/cache", ".dvc/tmp")
+}
+
+// Ignore R session data and package libraries
+// .Rproj.user next to *.Rproj, and the renv library next to renv.lock.
+var RProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	var ignores []string
+	if hasEntryMatching(entry, "*.Rproj") {
+		ignores = append(ignores, existingEntries(dir, entry, ".Rproj.user")...)
+	}
+	if containsAny(entryNames(entry), "renv.lock") {
+		ignores = append(ignores, existingEntries(dir, entry, "renv/library", "renv/staging")...)
+	}
+	return ignores
+}
+
+// Ignore Julia build artifacts
+// If it contains Project.toml and a src directory with Julia files, the
+// deps/build.jl outputs and the Documenter build are ignored.
+var JuliaProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "Project.toml", "JuliaProject.toml") ||
+		len(childDirsContaining(dir, entry, "*.jl")) == 0 {
+		return nil
+	}
+	return existingEntries(dir, entry, "deps/usr", "deps/deps.jl", "deps/build.log", "docs/build")
+}
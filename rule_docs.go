@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+)
+
+// Auxiliary files of LaTeX builds, emitted as file patterns.
+var latexOutputs = []string{
+	"*.aux", "*.log", "*.synctex.gz", "*.synctex(busy)", "*.fls", "*.fdb_latexmk",
+	"*.out", "*.toc", "*.lof", "*.lot", "*.bbl", "*.blg", "*.bcf", "*.run.xml",
+	"*.nav", "*.snm", "*.xdv", "_minted-*",
+}
+
+// Ignore LaTeX auxiliary files
+// The outputs matching latexOutputs next to *.tex files.
+var LatexProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, "*.tex") {
+		return nil
+	}
+	return matchingPatterns(entry, latexOutputs...)
+}
+
+// Ignore Hugo generated files
+// If it contains a hugo config, or a config.toml with a content
+// directory, it is considered a Hugo site.
+var HugoProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	if !containsAny(filenames, "hugo.toml", "hugo.yaml", "hugo.json") &&
+		!(containsAny(filenames, "config.toml", "config.yaml") && containsAny(filenames, "content", "archetypes")) {
+		return nil
+	}
+	return existingEntries(dir, entry, "resources/_gen", "public", ".hugo_build.lock")
+}
+
+// Ignore Jekyll generated files
+// If it contains _config.yml, it is considered a Jekyll site.
+var JekyllProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "_config.yml", "_config.yaml") {
+		return nil
+	}
+	return existingEntries(dir, entry, "_site", ".jekyll-cache", ".jekyll-metadata", ".sass-cache")
+}
+
+// Ignore MkDocs and Sphinx builds
+// site next to mkdocs.yml, _build next to conf.py, and build when the
+// Sphinx sources are in a separate source directory.
+var DocsBuildStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	var ignores []string
+	if containsAny(filenames, "mkdocs.yml", "mkdocs.yaml") {
+		ignores = append(ignores, existingEntries(dir, entry, "site")...)
+	}
+	if containsAny(filenames, "conf.py") {
+		ignores = append(ignores, existingEntries(dir, entry, "_build")...)
+	}
+	if len(existingEntries(dir, entry, "source/conf.py")) > 0 {
+		ignores = append(ignores, existingEntries(dir, entry, "build", "_build")...)
+	}
+	return ignores
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore Unity generated files
+// If it contains ProjectSettings/ProjectVersion.txt, it is considered a
+// Unity project. Build output directories are named Build or Builds by
+// convention.
+var UnityProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if _, err := os.Stat(filepath.Join(dir, "ProjectSettings", "ProjectVersion.txt")); err != nil {
+		return nil
+	}
+	ignores := existingEntries(dir, entry, "Library", "Temp", "Obj", "Logs", "UserSettings")
+	for _, v := range entry {
+		if v.IsDir() && strings.HasPrefix(v.Name(), "Build") {
+			ignores = append(ignores, v.Name())
+		}
+	}
+	return ignores
+}
+
+// Ignore Unreal Engine generated files
+// If it contains a *.uproject file, it is considered an Unreal project
+var UnrealProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, "*.uproject") {
+		return nil
+	}
+	return existingEntries(dir, entry, "Binaries", "Intermediate", "Saved", "DerivedDataCache")
+}
+
+// Ignore Godot import caches
+// If it contains project.godot, it is considered a Godot project
+var GodotProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "project.godot") {
+		return nil
+	}
+	return existingEntries(dir, entry, ".godot", ".import")
+}
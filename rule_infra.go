@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+)
+
+// Ignore Terraform and Terragrunt caches
+// If it contains *.tf files, .terraform is ignored. terragrunt.hcl
+// triggers .terragrunt-cache.
+var TerraformProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	var ignores []string
+	if hasEntryMatching(entry, "*.tf", "*.tf.json") {
+		ignores = append(ignores, existingEntries(dir, entry, ".terraform")...)
+	}
+	if containsAny(entryNames(entry), "terragrunt.hcl") {
+		ignores = append(ignores, existingEntries(dir, entry, ".terragrunt-cache")...)
+	}
+	return ignores
+}
+
+// Ignore Pulumi build files
+// The TypeScript templates compile to bin next to Pulumi.yaml.
+var PulumiProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	if !containsAny(filenames, "Pulumi.yaml", "Pulumi.yml") || !containsAny(filenames, "tsconfig.json") {
+		return nil
+	}
+	return existingEntries(dir, entry, "bin")
+}
+
+// Ignore Vagrant machine state
+// If it contains a Vagrantfile, .vagrant is ignored.
+var VagrantProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "Vagrantfile") {
+		return nil
+	}
+	return existingEntries(dir, entry, ".vagrant")
+}
+
+// Ignore Serverless Framework packages
+// If it contains a serverless config, .serverless is ignored.
+var ServerlessProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "serverless.yml", "serverless.yaml", "serverless.ts", "serverless.js", "serverless.json") {
+		return nil
+	}
+	return existingEntries(dir, entry, ".serverless")
+}
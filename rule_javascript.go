@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// markerChecker returns a checker ignoring the existing outputs next to
+// any of the markers, markers may be globs.
+func markerChecker(markers []string, outputs ...string) StIgnoreCheckFunc {
+	return func(dir string, entry []os.DirEntry) []string {
+		if !hasEntryMatching(entry, markers...) {
+			return nil
+		}
+		return existingEntries(dir, entry, outputs...)
+	}
+}
+
+var (
+	nextjsMarkers    = []string{"next.config.*"}
+	nuxtMarkers      = []string{"nuxt.config.*"}
+	svelteKitMarkers = []string{"svelte.config.*"}
+	angularMarkers   = []string{"angular.json"}
+	viteMarkers      = []string{"vite.config.*", "vitest.config.*"}
+	parcelMarkers    = []string{".parcelrc"}
+	turboMarkers     = []string{"turbo.json"}
+	nxMarkers        = []string{"nx.json"}
+	yarnBerryMarkers = []string{".yarnrc.yml"}
+	pnpmMarkers      = []string{"pnpm-lock.yaml", "pnpm-workspace.yaml"}
+	denoMarkers      = []string{"deno.json", "deno.jsonc"}
+	bunMarkers       = []string{"bun.lockb", "bun.lock", "bunfig.toml"}
+	storybookMarkers = []string{".storybook"}
+)
+
+// Ignore Next.js build files
+var NextjsProjectStIgnoreChecker = markerChecker(nextjsMarkers, ".next", "out")
+
+// Ignore Nuxt build files
+var NuxtProjectStIgnoreChecker = markerChecker(nuxtMarkers, ".nuxt", ".output")
+
+// Ignore SvelteKit build files
+var SvelteKitProjectStIgnoreChecker = markerChecker(svelteKitMarkers, ".svelte-kit")
+
+// Ignore Angular cache
+var AngularProjectStIgnoreChecker = markerChecker(angularMarkers, ".angular")
+
+// Ignore Vite build files and the dependency pre-bundling cache
+var ViteProjectStIgnoreChecker = markerChecker(viteMarkers, "dist", "node_modules/.vite", "node_modules/.vitest")
+
+// Ignore Parcel cache
+var ParcelProjectStIgnoreChecker = markerChecker(parcelMarkers, ".parcel-cache", "dist")
+
+// Ignore Turborepo cache
+// .turbo is created in the root and in every workspace package.
+var TurboProjectStIgnoreChecker = markerChecker(append([]string{"package.json"}, turboMarkers...), ".turbo")
+
+// Ignore Nx cache
+var NxProjectStIgnoreChecker = markerChecker(nxMarkers, ".nx/cache", ".nx/workspace-data")
+
+// Ignore Yarn Berry install files
+// .yarn/cache is kept when the project uses zero-installs, i.e. the
+// .gitignore keeps it with !.yarn/cache.
+var YarnBerryProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, yarnBerryMarkers...) {
+		return nil
+	}
+	outputs := []string{".yarn/unplugged", ".yarn/install-state.gz", ".yarn/build-state.yml"}
+	gitignore, _ := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if !bytes.Contains(gitignore, []byte("!.yarn/cache")) {
+		outputs = append(outputs, ".yarn/cache")
+	}
+	return existingEntries(dir, entry, outputs...)
+}
+
+// Ignore a project local pnpm store, and node_modules when it is a
+// symlink, e.g. linked by a workspace tool into a package
+var PnpmProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	ignores := markerChecker(pnpmMarkers, ".pnpm-store")(dir, entry)
+	if containsAny(entryNames(entry), "package.json") {
+		ignores = append(ignores, symlinkEntries(entry, "node_modules")...)
+	}
+	return ignores
+}
+
+// Ignore Deno node_modules and vendor
+// vendor is only ignored when deno.json enables it.
+var DenoProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, denoMarkers...) {
+		return nil
+	}
+	outputs := []string{"node_modules"}
+	for _, name := range denoMarkers {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && bytes.Contains(content, []byte(`"vendor"`)) {
+			outputs = append(outputs, "vendor")
+			break
+		}
+	}
+	return existingEntries(dir, entry, outputs...)
+}
+
+// Ignore Bun node_modules
+var BunProjectStIgnoreChecker = markerChecker(bunMarkers, "node_modules")
+
+// Ignore Storybook static build
+var StorybookProjectStIgnoreChecker = markerChecker(storybookMarkers, "storybook-static")
@@ -0,0 +1,32 @@
+package main
+
+// Files created by operating systems and editors, ignored at any depth
+// by the opt-in junk rule.
+var junkPatterns = []string{
+	// macOS
+	".DS_Store",
+	"._*",
+	".AppleDouble",
+	".LSOverride",
+	".Spotlight-V100",
+	".Trashes",
+	".fseventsd",
+	".TemporaryItems",
+	// Windows
+	"Thumbs.db",
+	"ehthumbs.db",
+	"desktop.ini",
+	"$RECYCLE.BIN",
+	"~$*",
+	// Linux desktop
+	".directory",
+	".Trash-*",
+	".nfs*",
+	// Editors
+	"*.swp",
+	"*.swo",
+	"*~",
+	".#*",
+	".idea/workspace.xml",
+	".idea/shelf",
+}
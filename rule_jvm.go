@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+)
+
+// Files Gradle leaves in a build directory, used to tell it apart from
+// a committed directory that happens to be called build.
+var gradleBuildOutputs = []string{"classes", "generated", "intermediates", "kotlin", "libs", "outputs", "reports", "test-results", "tmp"}
+
+// Ignore Maven build files
+// If it contains pom.xml, it is considered a Maven project
+var MavenProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "pom.xml") {
+		return nil
+	}
+	return existingEntries(dir, entry, "target")
+}
+
+// Ignore Gradle caches and build files
+// If it contains a Gradle build or settings script or the wrapper, it is
+// considered a Gradle project. build is only ignored when it contains
+// Gradle outputs, build next to build.gradle is left to the android rule.
+var GradleProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	if !containsAny(filenames, "build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts", "gradlew") {
+		return nil
+	}
+	ignores := existingEntries(dir, entry, ".gradle", ".kotlin")
+	if !containsAny(filenames, "build.gradle", "build.gradle.kts") && dirContainsAny(dir, "build", gradleBuildOutputs...) {
+		ignores = append(ignores, "build")
+	}
+	if dirContainsAny(dir, "buildSrc/build", gradleBuildOutputs...) {
+		ignores = append(ignores, "buildSrc/build")
+	}
+	return ignores
+}
+
+// Ignore sbt build files and Scala tooling
+// If it contains build.sbt, it is considered a sbt project
+var SbtProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "build.sbt") {
+		return nil
+	}
+	return existingEntries(dir, entry, "target", "project/target", "project/project", ".bsp", ".bloop", ".metals")
+}
+
+// Ignore Clojure build files
+// Leiningen (project.clj), tools.deps (deps.edn) and shadow-cljs
+// (shadow-cljs.edn) each have their own output directory.
+var ClojureProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	var ignores []string
+	if containsAny(filenames, "project.clj") {
+		ignores = append(ignores, existingEntries(dir, entry, "target")...)
+	}
+	if containsAny(filenames, "deps.edn") {
+		ignores = append(ignores, existingEntries(dir, entry, ".cpcache")...)
+	}
+	if containsAny(filenames, "shadow-cljs.edn") {
+		ignores = append(ignores, existingEntries(dir, entry, ".shadow-cljs")...)
+	}
+	return ignores
+}
+
+// Ignore Bazel output symlinks
+// If it contains a WORKSPACE or MODULE.bazel file, the bazel-* symlinks
+// (bazel-bin, bazel-out, bazel-testlogs, bazel-<workspace>) are ignored.
+var BazelProjectStIgnoreChecker = func(_ string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel") {
+		return nil
+	}
+	return symlinkEntries(entry, "bazel-*")
+}
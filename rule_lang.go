@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+)
+
+// Ignore the Go vendor directory, opt-in
+// vendor is ignored next to go.mod when it was created by `go mod vendor`.
+var GoVendorStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "go.mod") {
+		return nil
+	}
+	if !dirContainsAny(dir, "vendor", "modules.txt") {
+		return nil
+	}
+	return []string{"vendor"}
+}
+
+// Ignore Go binaries built by a Makefile, opt-in
+// bin is ignored next to go.mod and a Makefile.
+var GoBinStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	if !containsAny(filenames, "go.mod") || !containsAny(filenames, "Makefile", "makefile", "GNUmakefile") {
+		return nil
+	}
+	return existingEntries(dir, entry, "bin")
+}
+
+// Ignore Zig build files
+// If it contains build.zig, it is considered a Zig project
+var ZigProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "build.zig") {
+		return nil
+	}
+	return existingEntries(dir, entry, "zig-cache", ".zig-cache", "zig-out")
+}
+
+// Ignore Haskell build files
+// Stack (stack.yaml) builds into .stack-work, cabal (cabal.project or
+// *.cabal) into dist-newstyle.
+var HaskellProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	var ignores []string
+	if containsAny(filenames, "stack.yaml") {
+		ignores = append(ignores, existingEntries(dir, entry, ".stack-work")...)
+	}
+	if containsAny(filenames, "cabal.project") || hasEntryMatching(entry, "*.cabal") {
+		ignores = append(ignores, existingEntries(dir, entry, "dist-newstyle")...)
+	}
+	return ignores
+}
+
+// Ignore OCaml build files
+// If it contains dune-project, _build and the local opam switch _opam
+// are ignored.
+var OCamlProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "dune-project") {
+		return nil
+	}
+	return existingEntries(dir, entry, "_build", "_opam")
+}
+
+// Ignore Elixir build files
+// If it contains mix.exs, it is considered an Elixir project
+var ElixirProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "mix.exs") {
+		return nil
+	}
+	return existingEntries(dir, entry, "_build", "deps", ".elixir_ls")
+}
+
+// Ignore Erlang rebar3 build files
+// If it contains rebar.config, it is considered a rebar3 project
+var ErlangProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "rebar.config") {
+		return nil
+	}
+	return existingEntries(dir, entry, "_build")
+}
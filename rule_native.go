@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Ignore .NET build files
+// If it contains a project or solution file, it is considered a .NET
+// project. bin is only ignored when it contains build configurations.
+var DotnetProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !hasEntryMatching(entry, "*.csproj", "*.fsproj", "*.vbproj", "*.sln", "*.slnx") {
+		return nil
+	}
+	ignores := existingEntries(dir, entry, "obj", ".vs")
+	if dirContainsAny(dir, "bin", "Debug", "Release") {
+		ignores = append(ignores, "bin")
+	}
+	return ignores
+}
+
+// Ignore CMake build directories
+// Next to CMakeLists.txt, any directory containing CMakeCache.txt is a
+// build directory (build, build-release, cmake-build-debug, out, ...).
+var CMakeProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "CMakeLists.txt") {
+		return nil
+	}
+	return childDirsContaining(dir, entry, "CMakeCache.txt")
+}
+
+// Ignore Meson build directories
+// Next to meson.build, any directory containing meson-private is a build
+// directory.
+var MesonProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "meson.build") {
+		return nil
+	}
+	return childDirsContaining(dir, entry, "meson-private")
+}
+
+// Ignore Autotools outputs
+// The generated files next to configure.ac, and the objects in every
+// directory automake tracks with .deps or .libs.
+var AutotoolsProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	var ignores []string
+	if containsAny(filenames, "configure.ac", "configure.in") {
+		ignores = append(ignores, existingEntries(dir, entry, "autom4te.cache", "config.log", "config.status")...)
+	}
+	if containsAny(filenames, ".deps", ".libs") {
+		ignores = append(ignores, existingEntries(dir, entry, ".deps", ".libs")...)
+		ignores = append(ignores, matchingPatterns(entry, "*.o", "*.lo", "*.la")...)
+	}
+	return ignores
+}
+
+// Ignore vcpkg manifest mode installs
+// If it contains vcpkg.json, vcpkg_installed is ignored.
+var VcpkgProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "vcpkg.json") {
+		return nil
+	}
+	return existingEntries(dir, entry, "vcpkg_installed")
+}
+
+// Ignore Conan build folders
+// Next to a conanfile, directories holding the files Conan generates
+// (conaninfo.txt, conanbuildinfo.txt, conan_toolchain.cmake) are ignored.
+var ConanProjectStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	if !containsAny(entryNames(entry), "conanfile.txt", "conanfile.py") {
+		return nil
+	}
+	return childDirsContaining(dir, entry,
+		"conaninfo.txt",
+		"conanbuildinfo.txt",
+		filepath.Join("generators", "conan_toolchain.cmake"),
+		filepath.Join("*", "generators", "conan_toolchain.cmake"),
+	)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore Python virtual environments
+// A directory containing pyvenv.cfg is a virtual environment whatever its
+// name. .venv (Poetry, PDM, Hatch, uv) is also ignored next to the project
+// files in case it is not initialized yet.
+var PythonVenvStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	var ignores []string
+	for _, v := range entry {
+		if !v.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, v.Name(), "pyvenv.cfg")); err == nil {
+			ignores = append(ignores, v.Name())
+		}
+	}
+	filenames := entryNames(entry)
+	if containsAny(filenames, "pyproject.toml", "poetry.lock", "pdm.lock", "uv.lock") &&
+		containsAny(filenames, ".venv") && !containsAny(ignores, ".venv") {
+		ignores = append(ignores, ".venv")
+	}
+	return ignores
+}
+
+// Ignore Python caches
+// __pycache__ and the caches of pytest, mypy, ruff and Jupyter are
+// ignored wherever they are.
+var PythonCacheStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	return existingEntries(dir, entry, "__pycache__", ".pytest_cache", ".mypy_cache", ".ruff_cache", ".ipynb_checkpoints")
+}
+
+// Ignore Python build files
+// build, dist and *.egg-info next to pyproject.toml or setup.py, and the
+// tox/nox environments.
+var PythonBuildStIgnoreChecker = func(dir string, entry []os.DirEntry) []string {
+	filenames := entryNames(entry)
+	var ignores []string
+	if containsAny(filenames, "pyproject.toml", "setup.py", "setup.cfg") {
+		ignores = append(ignores, existingEntries(dir, entry, "build", "dist")...)
+		for _, v := range entry {
+			if v.IsDir() && strings.HasSuffix(v.Name(), ".egg-info") {
+				ignores = append(ignores, v.Name())
+			}
+		}
+	}
+	if containsAny(filenames, "tox.ini", "pyproject.toml", "setup.cfg") {
+		ignores = append(ignores, existingEntries(dir, entry, ".tox")...)
+	}
+	if containsAny(filenames, "noxfile.py") {
+		ignores = append(ignores, existingEntries(dir, entry, ".nox")...)
+	}
+	return ignores
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type ruleTestCase struct {
+	name     string
+	tree     []string
+	expected []string
+}
+
+// testRule scans each sample tree with the single rule name.
+func testRule(t *testing.T, name string, cases []ruleTestCase) {
+	t.Helper()
+	rule, ok := findRule(name)
+	if !ok {
+		t.Fatalf("rule %s not found", name)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := makeTree(t, c.tree...)
+			got := scanTree(t, root, []IgnoreRule{rule})
+			slices.Sort(got)
+			slices.Sort(c.expected)
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("Unexpected ignores. Got %v, expected %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestMavenRule(t *testing.T) {
+	testRule(t, "maven", []ruleTestCase{
+		{"Project", []string{"app/pom.xml", "app/src/", "app/target/classes/"}, []string{"/app/target"}},
+		{"NotBuilt", []string{"app/pom.xml", "app/src/"}, nil},
+		{"NoPom", []string{"app/target/"}, nil},
+	})
+}
+
+func TestGradleRule(t *testing.T) {
+	testRule(t, "gradle", []ruleTestCase{
+		{"Project", []string{
+			"settings.gradle.kts", "gradlew", ".gradle/", ".kotlin/",
+			"build/tmp/", "buildSrc/build/classes/",
+			"app/build.gradle.kts", "app/build/intermediates/",
+		}, []string{"/.gradle", "/.kotlin", "/build", "/buildSrc/build"}},
+		{"CommittedBuildDir", []string{"settings.gradle", "build/notes.md"}, nil},
+		// left to the android rule
+		{"AndroidBuildDir", []string{"build.gradle", "build/outputs/"}, nil},
+	})
+}
+
+func TestSbtRule(t *testing.T) {
+	testRule(t, "sbt", []ruleTestCase{
+		{"Project", []string{
+			"build.sbt", "target/", "project/build.properties", "project/target/",
+			".bsp/", ".bloop/", ".metals/",
+		}, []string{"/target", "/project/target", "/.bsp", "/.bloop", "/.metals"}},
+		{"NoBuildSbt", []string{"target/", ".metals/"}, nil},
+	})
+}
+
+func TestClojureRule(t *testing.T) {
+	testRule(t, "clojure", []ruleTestCase{
+		{"Leiningen", []string{"project.clj", "target/"}, []string{"/target"}},
+		{"DepsAndShadow", []string{"deps.edn", "shadow-cljs.edn", ".cpcache/", ".shadow-cljs/", "target/"}, []string{"/.cpcache", "/.shadow-cljs"}},
+	})
+}
+
+func TestBazelRule(t *testing.T) {
+	root := makeTree(t, "java/MODULE.bazel", "java/src/", "outputs/bin/", "java/bazel-notalink/")
+	for _, link := range []string{"bazel-bin", "bazel-out", "bazel-java"} {
+		err := os.Symlink(filepath.Join(root, "outputs", "bin"), filepath.Join(root, "java", link))
+		if err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	rule, _ := findRule("bazel")
+	got := scanTree(t, root, []IgnoreRule{rule})
+	expected := []string{"/java/bazel-bin", "/java/bazel-java", "/java/bazel-out"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+// typedEntry is a directory entry of a given type, e.g. a Windows
+// junction reported as irregular.
+type typedEntry struct {
+	name string
+	typ  fs.FileMode
+}
+
+func (e typedEntry) Name() string               { return e.name }
+func (e typedEntry) IsDir() bool                { return e.typ.IsDir() }
+func (e typedEntry) Type() fs.FileMode          { return e.typ }
+func (e typedEntry) Info() (fs.FileInfo, error) { return nil, fs.ErrNotExist }
+
+func TestSymlinkEntries(t *testing.T) {
+	entries := []os.DirEntry{
+		typedEntry{"node_modules", fs.ModeDir},
+		typedEntry{"bazel-bin", fs.ModeSymlink},
+		typedEntry{"bazel-out", fs.ModeIrregular},
+		typedEntry{"bazel-java", 0},
+	}
+	var expected []string
+	for _, e := range entries {
+		if isLink(e) {
+			expected = append(expected, e.Name())
+		}
+	}
+	got := symlinkEntries(entries, "bazel-*")
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected links. Got %v, expected %v", got, expected)
+	}
+	if !slices.Contains(got, "bazel-bin") {
+		t.Errorf("Expected the symlink to be found, got %v", got)
+	}
+}
+
+func TestPythonVenvRule(t *testing.T) {
+	testRule(t, "python-venv", []ruleTestCase{
+		{"AnyName", []string{"env/pyvenv.cfg", "env/lib/", "tools/myenv/pyvenv.cfg"}, []string{"/env", "/tools/myenv"}},
+		{"UvProject", []string{"pyproject.toml", "uv.lock", ".venv/lib/"}, []string{"/.venv"}},
+		{"PlainDir", []string{"venv/readme.md"}, nil},
+	})
+}
+
+func TestPythonCacheRule(t *testing.T) {
+	testRule(t, "python-cache", []ruleTestCase{
+		{"Caches", []string{
+			"__pycache__/", "pkg/__pycache__/", ".pytest_cache/", ".mypy_cache/", ".ruff_cache/",
+			"notebooks/.ipynb_checkpoints/",
+		}, []string{"/__pycache__", "/pkg/__pycache__", "/.pytest_cache", "/.mypy_cache", "/.ruff_cache", "/notebooks/.ipynb_checkpoints"}},
+	})
+}
+
+func TestPythonBuildRule(t *testing.T) {
+	testRule(t, "python-build", []ruleTestCase{
+		{"Pyproject", []string{"pyproject.toml", "build/", "dist/", "src/demo.egg-info/", "demo.egg-info/", ".tox/"},
+			[]string{"/build", "/dist", "/demo.egg-info", "/.tox"}},
+		{"Nox", []string{"setup.py", "noxfile.py", ".nox/"}, []string{"/.nox"}},
+		{"NoProject", []string{"build/", "dist/"}, nil},
+	})
+}
+
+func TestDotnetRule(t *testing.T) {
+	testRule(t, "dotnet", []ruleTestCase{
+		{"Solution", []string{"App.sln", ".vs/", "App/App.csproj", "App/bin/Debug/", "App/obj/"},
+			[]string{"/.vs", "/App/bin", "/App/obj"}},
+		{"CommittedBin", []string{"Tool.fsproj", "bin/run.sh"}, nil},
+	})
+}
+
+func TestCMakeRule(t *testing.T) {
+	testRule(t, "cmake", []ruleTestCase{
+		{"BuildDirs", []string{
+			"CMakeLists.txt", "build/CMakeCache.txt", "cmake-build-debug/CMakeCache.txt", "out/CMakeCache.txt",
+			"build-docs/index.md",
+		}, []string{"/build", "/cmake-build-debug", "/out"}},
+		{"NoCMakeLists", []string{"build/CMakeCache.txt"}, nil},
+	})
+}
+
+func TestMesonRule(t *testing.T) {
+	testRule(t, "meson", []ruleTestCase{
+		{"BuildDir", []string{"meson.build", "builddir/meson-private/", "subprojects/"}, []string{"/builddir"}},
+	})
+}
+
+func TestAutotoolsRule(t *testing.T) {
+	testRule(t, "autotools", []ruleTestCase{
+		{"Project", []string{
+			"configure.ac", "autom4te.cache/", "config.log", "config.status",
+			"src/.deps/", "src/main.o", "src/main.c", "lib/.libs/", "lib/util.lo", "lib/libutil.la",
+		}, []string{"/autom4te.cache", "/config.log", "/config.status", "/src/.deps", "/src/*.o", "/lib/.libs", "/lib/*.lo", "/lib/*.la"}},
+	})
+}
+
+func TestVcpkgRule(t *testing.T) {
+	testRule(t, "vcpkg", []ruleTestCase{
+		{"Manifest", []string{"vcpkg.json", "vcpkg_installed/"}, []string{"/vcpkg_installed"}},
+	})
+}
+
+func TestConanRule(t *testing.T) {
+	testRule(t, "conan", []ruleTestCase{
+		{"Conan2", []string{"conanfile.py", "build/Release/generators/conan_toolchain.cmake", "src/"}, []string{"/build"}},
+		{"Conan1", []string{"conanfile.txt", "cmake-build/conanbuildinfo.txt"}, []string{"/cmake-build"}},
+	})
+}
+
+func TestGoRules(t *testing.T) {
+	testRule(t, "go-vendor", []ruleTestCase{
+		{"Vendored", []string{"go.mod", "vendor/modules.txt"}, []string{"/vendor"}},
+		{"NotVendored", []string{"go.mod", "vendor/readme.md"}, nil},
+	})
+	testRule(t, "go-bin", []ruleTestCase{
+		{"Makefile", []string{"go.mod", "Makefile", "bin/"}, []string{"/bin"}},
+		{"NoMakefile", []string{"go.mod", "bin/"}, nil},
+	})
+}
+
+func TestGoRulesOptIn(t *testing.T) {
+	cfg := defaultConfig()
+	rules := cfg.FolderSettings(syncFolder{}).Rules(StIgnoreRules)
+	if slices.ContainsFunc(rules, func(r IgnoreRule) bool { return r.Name == "go-vendor" }) {
+		t.Errorf("go-vendor should be disabled by default")
+	}
+	cfg.Folder = []FolderOverride{{ID: "code", EnableRules: []string{"go-vendor"}}}
+	rules = cfg.FolderSettings(syncFolder{ID: "code"}).Rules(StIgnoreRules)
+	if !slices.ContainsFunc(rules, func(r IgnoreRule) bool { return r.Name == "go-vendor" }) {
+		t.Errorf("go-vendor should be enabled for folder code")
+	}
+}
+
+func TestLangRules(t *testing.T) {
+	testRule(t, "zig", []ruleTestCase{
+		{"Project", []string{"build.zig", "zig-cache/", ".zig-cache/", "zig-out/"}, []string{"/zig-cache", "/.zig-cache", "/zig-out"}},
+	})
+	testRule(t, "haskell", []ruleTestCase{
+		{"Stack", []string{"stack.yaml", ".stack-work/"}, []string{"/.stack-work"}},
+		{"Cabal", []string{"demo.cabal", "dist-newstyle/"}, []string{"/dist-newstyle"}},
+	})
+	testRule(t, "ocaml", []ruleTestCase{
+		{"Dune", []string{"dune-project", "_build/", "_opam/"}, []string{"/_build", "/_opam"}},
+	})
+	testRule(t, "elixir", []ruleTestCase{
+		{"Mix", []string{"mix.exs", "_build/", "deps/", ".elixir_ls/"}, []string{"/_build", "/deps", "/.elixir_ls"}},
+	})
+	testRule(t, "erlang", []ruleTestCase{
+		{"Rebar3", []string{"rebar.config", "_build/"}, []string{"/_build"}},
+		{"NoRebar", []string{"_build/"}, nil},
+	})
+}
+
+func TestJSFrameworkRules(t *testing.T) {
+	testRule(t, "nextjs", []ruleTestCase{
+		{"Next", []string{"next.config.mjs", ".next/", "out/"}, []string{"/.next", "/out"}},
+		{"NoConfig", []string{"package.json", ".next/"}, nil},
+	})
+	testRule(t, "nuxt", []ruleTestCase{
+		{"Nuxt", []string{"nuxt.config.ts", ".nuxt/", ".output/"}, []string{"/.nuxt", "/.output"}},
+	})
+	testRule(t, "sveltekit", []ruleTestCase{
+		{"SvelteKit", []string{"svelte.config.js", ".svelte-kit/"}, []string{"/.svelte-kit"}},
+	})
+	testRule(t, "angular", []ruleTestCase{
+		{"Angular", []string{"angular.json", ".angular/cache/"}, []string{"/.angular"}},
+	})
+	testRule(t, "vite", []ruleTestCase{
+		{"Vite", []string{"vite.config.ts", "dist/", "node_modules/.vite/"}, []string{"/dist", "/node_modules/.vite"}},
+	})
+	testRule(t, "parcel", []ruleTestCase{
+		{"Parcel", []string{".parcelrc", ".parcel-cache/"}, []string{"/.parcel-cache"}},
+	})
+	testRule(t, "turbo", []ruleTestCase{
+		{"Monorepo", []string{"turbo.json", "package.json", ".turbo/", "packages/ui/package.json", "packages/ui/.turbo/"},
+			[]string{"/.turbo", "/packages/ui/.turbo"}},
+	})
+	testRule(t, "nx", []ruleTestCase{
+		{"Nx", []string{"nx.json", ".nx/cache/", ".nx/workspace-data/", ".nx/installation/"}, []string{"/.nx/cache", "/.nx/workspace-data"}},
+	})
+	testRule(t, "pnpm", []ruleTestCase{
+		{"Store", []string{"pnpm-lock.yaml", ".pnpm-store/"}, []string{"/.pnpm-store"}},
+	})
+	testRule(t, "bun", []ruleTestCase{
+		{"Bun", []string{"bun.lock", "node_modules/"}, []string{"/node_modules"}},
+	})
+	testRule(t, "storybook", []ruleTestCase{
+		{"Storybook", []string{".storybook/main.ts", "storybook-static/"}, []string{"/storybook-static"}},
+	})
+}
+
+func TestYarnBerryRule(t *testing.T) {
+	testRule(t, "yarn-berry", []ruleTestCase{
+		{"Cache", []string{".yarnrc.yml", ".yarn/cache/", ".yarn/releases/", ".yarn/install-state.gz"},
+			[]string{"/.yarn/cache", "/.yarn/install-state.gz"}},
+	})
+
+	root := makeTree(t, ".yarnrc.yml", ".yarn/cache/", ".yarn/unplugged/", ".pnp.cjs")
+	err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte(".yarn/*\n!.yarn/cache\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	rule, _ := findRule("yarn-berry")
+	got := scanTree(t, root, []IgnoreRule{rule})
+	if expected := []string{"/.yarn/unplugged"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("zero-installs: unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestDenoRule(t *testing.T) {
+	root := makeTree(t, "node_modules/", "vendor/")
+	err := os.WriteFile(filepath.Join(root, "deno.json"), []byte(`{"vendor": true, "nodeModulesDir": "auto"}`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write deno.json: %v", err)
+	}
+	rule, _ := findRule("deno")
+	got := scanTree(t, root, []IgnoreRule{rule})
+	if expected := []string{"/node_modules", "/vendor"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestNestedIgnoreCoveredByParent(t *testing.T) {
+	root := makeTree(t, "package.json", "vite.config.js", "node_modules/.vite/")
+	got := scanTree(t, root, defaultConfig().FolderSettings(syncFolder{}).Rules(StIgnoreRules))
+	if expected := []string{"/node_modules", "/dist"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestGameEngineRules(t *testing.T) {
+	testRule(t, "unity", []ruleTestCase{
+		{"Unity", []string{
+			"ProjectSettings/ProjectVersion.txt", "Assets/", "Library/", "Temp/", "Obj/", "Logs/", "UserSettings/",
+			"Builds/", "BuildWin/",
+		}, []string{"/Library", "/Temp", "/Obj", "/Logs", "/UserSettings", "/BuildWin", "/Builds"}},
+		{"NotUnity", []string{"Library/", "Temp/"}, nil},
+	})
+	testRule(t, "unreal", []ruleTestCase{
+		{"Unreal", []string{"Game.uproject", "Binaries/", "Intermediate/", "Saved/", "DerivedDataCache/", "Content/"},
+			[]string{"/Binaries", "/Intermediate", "/Saved", "/DerivedDataCache"}},
+	})
+	testRule(t, "godot", []ruleTestCase{
+		{"Godot", []string{"project.godot", ".godot/", ".import/"}, []string{"/.godot", "/.import"}},
+	})
+}
+
+func TestAppleRules(t *testing.T) {
+	testRule(t, "xcode", []ruleTestCase{
+		{"Xcode", []string{"App.xcodeproj/xcuserdata/", "App.xcodeproj/project.pbxproj", "App.xcworkspace/xcuserdata/", "DerivedData/"},
+			[]string{"/DerivedData", "/App.xcodeproj/xcuserdata", "/App.xcworkspace/xcuserdata"}},
+	})
+	testRule(t, "cocoapods", []ruleTestCase{
+		{"Pods", []string{"Podfile", "Pods/"}, []string{"/Pods"}},
+	})
+	testRule(t, "swiftpm", []ruleTestCase{
+		{"Package", []string{"Package.swift", ".build/"}, []string{"/.build"}},
+	})
+	testRule(t, "dart", []ruleTestCase{
+		{"Flutter", []string{"pubspec.yaml", "pubspec.lock", ".dart_tool/", "ios/Pods/", "ios/Podfile"},
+			[]string{"/build", "/.dart_tool", "/ios/Pods"}},
+	})
+}
+
+func TestInfraRules(t *testing.T) {
+	testRule(t, "terraform", []ruleTestCase{
+		{"Terraform", []string{"infra/main.tf", "infra/.terraform/providers/", "live/terragrunt.hcl", "live/.terragrunt-cache/"},
+			[]string{"/infra/.terraform", "/live/.terragrunt-cache"}},
+		{"NoTf", []string{".terraform/"}, nil},
+	})
+	testRule(t, "vagrant", []ruleTestCase{
+		{"Vagrant", []string{"Vagrantfile", ".vagrant/machines/"}, []string{"/.vagrant"}},
+	})
+	testRule(t, "serverless", []ruleTestCase{
+		{"Serverless", []string{"serverless.yml", ".serverless/"}, []string{"/.serverless"}},
+	})
+}
+
+func TestDocsRules(t *testing.T) {
+	testRule(t, "latex", []ruleTestCase{
+		{"Latex", []string{
+			"paper/main.tex", "paper/main.aux", "paper/main.log", "paper/main.synctex.gz",
+			"paper/_minted-main/x.pygtex", "paper/figures/plot.log",
+		}, []string{"/paper/*.aux", "/paper/*.log", "/paper/*.synctex.gz", "/paper/_minted-*"}},
+		{"NoTex", []string{"logs/app.log"}, nil},
+	})
+	testRule(t, "hugo", []ruleTestCase{
+		{"Hugo", []string{"site/hugo.toml", "site/resources/_gen/images/", "site/public/"},
+			[]string{"/site/resources/_gen", "/site/public"}},
+		{"Legacy", []string{"config.toml", "content/", "public/"}, []string{"/public"}},
+		{"NotHugo", []string{"config.toml", "public/"}, nil},
+	})
+	testRule(t, "jekyll", []ruleTestCase{
+		{"Jekyll", []string{"_config.yml", "_site/", ".jekyll-cache/"}, []string{"/_site", "/.jekyll-cache"}},
+	})
+	testRule(t, "docs-build", []ruleTestCase{
+		{"MkDocs", []string{"mkdocs.yml", "site/"}, []string{"/site"}},
+		{"Sphinx", []string{"docs/conf.py", "docs/_build/"}, []string{"/docs/_build"}},
+		{"SphinxSource", []string{"docs/source/conf.py", "docs/build/"}, []string{"/docs/build"}},
+	})
+}
+
+func TestDataRules(t *testing.T) {
+	testRule(t, "dvc", []ruleTestCase{
+		{"Dvc", []string{".dvc/config", ".dvc/cache/", ".dvc/tmp/"}, []string{"/.dvc/cache", "/.dvc/tmp"}},
+	})
+	testRule(t, "r", []ruleTestCase{
+		{"R", []string{"analysis.Rproj", ".Rproj.user/", "renv.lock", "renv/library/", "renv/activate.R"},
+			[]string{"/.Rproj.user", "/renv/library"}},
+	})
+	testRule(t, "julia", []ruleTestCase{
+		{"Julia", []string{"Project.toml", "src/Pkg.jl", "deps/build.jl", "deps/usr/", "deps/build.log", "docs/build/"},
+			[]string{"/deps/usr", "/deps/build.log", "/docs/build"}},
+		{"NotJulia", []string{"Project.toml", "docs/build/"}, nil},
+	})
+}
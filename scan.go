@@ -1,10 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,15 +16,45 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// ParticleSkipFile turns rules off for the directory containing it and
+// its children. It lists one rule name per line, an empty file turns
+// off every rule.
+const ParticleSkipFile = ".particle-skip"
+
+// allRules stands for every rule in .particle-skip and directives.
+const allRules = "all"
+
 type dirScanner struct {
-	ignoreRules      []StIgnoreCheckFunc
+	ignoreRules      []IgnoreRule
 	ignoreRulesDir   func(dir string) bool
 	logger           *logrus.Logger
 	scanningDir      string
 	syncthingBinPath string
+	removeD          bool
+	// rules disabled by directives, keyed by subtree ("" for the root, "/a/b" for children)
+	subtreeDisabled map[string][]string
+	// paths ignored so far, not scanned
+	ignoredPaths map[string]bool
+	// unanchored patterns of the enabled rules
+	globalIgnores []string
+	settings      ScanSettings
+	// device of the folder root, checked when settings.OneFileSystem
+	rootDevice    uint64
+	hasRootDevice bool
+	// directories scanned when following symlinks, by fileKey
+	visited map[string]bool
+	stats   scanStats
+}
+
+// scanStats counts the work of the last ScanFolder.
+type scanStats struct {
+	// directories read
+	Dirs int
+	// patterns written by rule name
+	Patterns map[string]int
 }
 
-func NewDirScanner(ignoreRules []StIgnoreCheckFunc, syncthingBin string) *dirScanner {
+func NewDirScanner(ignoreRules []IgnoreRule, syncthingBin string) *dirScanner {
 	return &dirScanner{
 		ignoreRules:      ignoreRules,
 		logger:           logger,
@@ -32,41 +66,93 @@ func (d *dirScanner) SetIgnoreRulesDir(ignoreRulesDir func(dir string) bool) {
 	d.ignoreRulesDir = ignoreRulesDir
 }
 
-func (d *dirScanner) ScanToGenerateStIgnore(dir string, dirFetchFromWeb bool) (updated bool, err error) {
+// SetScanSettings sets where the scanner stops descending.
+func (d *dirScanner) SetScanSettings(settings ScanSettings) {
+	d.settings = settings
+}
+
+// SetRemoveD controls whether generated ignores get the '(?d)' prefix.
+func (d *dirScanner) SetRemoveD(removeD bool) {
+	d.removeD = removeD
+}
+
+// Stats returns the counters of the last ScanFolder.
+func (d *dirScanner) Stats() scanStats {
+	return d.stats
+}
+
+// ScanFolder scans localRootDir and returns the ignores to write into the
+// particle block of stIgnore. Directories ignored by the user written
+// lines of stIgnore are not scanned.
+func (d *dirScanner) ScanFolder(localRootDir string, stIgnore *stIgnoreEdit) ([]string, error) {
 	doneChan := make(chan struct{})
+	defer close(doneChan)
 	go d.logScanning(doneChan)
 
-	localRootDir, err := d.prepareDirectory(dir, dirFetchFromWeb)
+	ignoreRulesDir, err := stIgnore.GetBaseIgnoreCheckFunc()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	var stIgnoreFile = filepath.Join(localRootDir, ".stignore")
-	// d.logger.Infof("scan to generate stignore: %s", stIgnoreFile)
-	stIgnore, err := NewstIgnoreEdit(stIgnoreFile)
-	if err != nil {
-		return false, err
+	d.ignoreRulesDir = ignoreRulesDir
+	d.subtreeDisabled = stIgnore.DisabledRules()
+	d.ignoredPaths = make(map[string]bool)
+	d.hasRootDevice = false
+	d.visited = make(map[string]bool)
+	d.stats = scanStats{Patterns: make(map[string]int)}
+	if info, err := os.Stat(localRootDir); err == nil {
+		d.rootDevice, d.hasRootDevice = deviceID(info)
+	}
+	globals := d.globalPatterns()
+	d.globalIgnores = make([]string, 0, len(globals))
+	for _, v := range globals {
+		d.globalIgnores = append(d.globalIgnores, v.Name)
 	}
-	d.ignoreRulesDir = stIgnore.GetBaseIgnoreCheckFunc()
-	scannedIgnores, err := d.scanDir(localRootDir, "")
+	ignores, err := d.scanDir(localRootDir, "", nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	close(doneChan)
-
-	stIgnore.OverwriteIgnores(scannedIgnores)
+	// negations must come before the patterns they make exceptions to
+	slices.SortStableFunc(ignores, func(a, b Ignore) int {
+		if a.Negate != b.Negate {
+			if a.Negate {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	lines := make([]string, 0, len(d.globalIgnores)+len(ignores))
+	for _, v := range globals {
+		lines = append(lines, v.GlobalLine(d.removeD))
+		d.stats.Patterns[v.rule]++
+	}
+	for _, ignore := range ignores {
+		lines = append(lines, ignore.Line("", d.removeD))
+		d.stats.Patterns[ignore.rule]++
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return lines, nil
+}
 
-	updated, err = stIgnore.SetChange()
-	if err != nil {
-		return false, err
+// globalPatterns returns the global patterns of the rules not disabled
+// for the whole folder.
+func (d *dirScanner) globalPatterns() []Ignore {
+	disabled := d.subtreeDisabled[""]
+	if slices.Contains(disabled, allRules) {
+		return nil
 	}
-	if updated {
-		d.logger.Infof("Successfully updated settings in %s", localRootDir)
-	} else {
-		d.logger.Infof("No updates required for %s", localRootDir)
+	var patterns []Ignore
+	for _, v := range d.ignoreRules {
+		if slices.Contains(disabled, v.Name) {
+			continue
+		}
+		for _, pattern := range v.Global {
+			patterns = append(patterns, Ignore{Name: pattern, Deletable: v.Deletable, rule: v.Name})
+		}
 	}
-	fmt.Println()
-	return updated, nil
+	return patterns
 }
 
 // New helper functions
@@ -84,15 +170,11 @@ func (d *dirScanner) logScanning(doneChan chan struct{}) {
 }
 
 func (d *dirScanner) prepareDirectory(dir string, dirFetchFromWeb bool) (string, error) {
-	dir = filepath.ToSlash(dir)
-	if strings.HasPrefix(dir, "~/") {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", fmt.Errorf("failed to get home directory: %w", err)
-		}
-		dir = filepath.Join(homeDir, dir[2:])
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
 	}
-	var err error
+	dir = filepath.ToSlash(dir)
 	if dirFetchFromWeb && strings.HasPrefix(dir, "./") {
 		dir, err = d.resolveSyncthingPath(dir)
 		if err != nil {
@@ -127,41 +209,223 @@ func (d *dirScanner) resolveSyncthingPath(dir string) (string, error) {
 	return dir, nil
 }
 
-func (d *dirScanner) scanDir(dir string, parentsDir string) ([]string, error) {
+// disabledRules adds the rules turned off for dir by directives and by
+// ParticleSkipFile to the ones inherited from its parents.
+func (d *dirScanner) disabledRules(dir string, parentsDir string, entries []os.DirEntry, inherited map[string]bool) map[string]bool {
+	names := d.subtreeDisabled[parentsDir]
+	if slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == ParticleSkipFile }) {
+		skipNames, err := readParticleSkipFile(filepath.Join(dir, ParticleSkipFile))
+		if err != nil {
+			d.logger.Warnf("read %s error: %v", filepath.Join(dir, ParticleSkipFile), err)
+		}
+		names = append(slices.Clone(names), skipNames...)
+	}
+	if len(names) == 0 {
+		return inherited
+	}
+	disabled := maps.Clone(inherited)
+	if disabled == nil {
+		disabled = make(map[string]bool, len(names))
+	}
+	for _, name := range names {
+		disabled[name] = true
+	}
+	return disabled
+}
+
+// coveredByParent reports whether a parent directory of name is in names.
+func coveredByParent(name string, names []string) bool {
+	for _, n := range names {
+		if strings.HasPrefix(name, n+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// followSymlink applies the symlink policy to a symlink in dir, it
+// reports whether the symlink points to a directory to scan.
+func (d *dirScanner) followSymlink(dir string, entry os.DirEntry) bool {
+	p := filepath.Join(dir, entry.Name())
+	info, err := os.Stat(p)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	switch d.settings.Symlinks {
+	case symlinkFollow:
+		return true
+	case symlinkReport:
+		target, _ := os.Readlink(p)
+		d.logger.Warnf("symlinked dir not scanned: %s -> %s", p, target)
+	default:
+		d.logger.Debugf("skip symlinked dir: %s", p)
+	}
+	return false
+}
+
+// stopAt reports whether the child directory entry of dir must not be
+// scanned because of the scan settings.
+func (d *dirScanner) stopAt(dir string, entry os.DirEntry) bool {
+	if slices.Contains(d.settings.StopDirs, entry.Name()) {
+		return true
+	}
+	if !d.settings.OneFileSystem || !d.hasRootDevice {
+		return false
+	}
+	// the device of a link is the one of its target
+	info, err := entry.Info()
+	if isLink(entry) {
+		info, err = os.Stat(filepath.Join(dir, entry.Name()))
+	}
+	if err != nil {
+		return false
+	}
+	if device, ok := deviceID(info); ok && device != d.rootDevice {
+		d.logger.Infof("skip mount point: %s", filepath.Join(dir, entry.Name()))
+		return true
+	}
+	return false
+}
+
+// matchesGlob reports whether name matches one of the single component
+// patterns of names, e.g. _minted-paper for _minted-*.
+func matchesGlob(name string, names []string) bool {
+	for _, n := range names {
+		if strings.Contains(n, "/") {
+			continue
+		}
+		if ok, _ := path.Match(n, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readParticleSkipFile returns the rule names listed in a skip file,
+// or allRules if it lists none.
+func readParticleSkipFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []string{allRules}, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if len(names) == 0 {
+		return []string{allRules}, nil
+	}
+	return names, nil
+}
+
+// scanDir returns the ignores of dir and its children, their names are
+// relative to the folder root.
+func (d *dirScanner) scanDir(dir string, parentsDir string, disabled map[string]bool) ([]Ignore, error) {
 	if d.ignoreRulesDir != nil && d.ignoreRulesDir(dir) {
 		d.logger.Debugf("ignore dir: %s\n", dir)
 		return nil, nil
 	}
 
+	if d.settings.Symlinks == symlinkFollow {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		key := fileKey(dir, info)
+		if d.visited[key] {
+			d.logger.Infof("skip dir: %s, already scanned through a symlink", dir)
+			return nil, nil
+		}
+		d.visited[key] = true
+	}
+
 	d.scanningDir = dir
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
+	d.stats.Dirs++
+	disabled = d.disabledRules(dir, parentsDir, entries, disabled)
+	if disabled[allRules] {
+		d.logger.Debugf("all rules disabled in dir: %s\n", dir)
+		return nil, nil
+	}
+	if parentsDir != "" && d.settings.StopAtNestedFolders &&
+		slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == ".stfolder" }) {
+		d.logger.Infof("skip nested syncthing folder: %s", dir)
+		return nil, nil
+	}
 
-	var ignores []string
-	var ignoreNames = make(map[string]bool)
+	var found []Ignore
 	for _, v := range d.ignoreRules {
-		for _, ignoreName := range v(dir, entries) {
-			var ignorePath = parentsDir + "/" + ignoreName
-			if !*removeD {
-				ignorePath = "(?d)" + ignorePath
-			}
-			ignores = append(ignores, ignorePath) //+"/**"
-			ignoreNames[ignoreName] = true
+		if disabled[v.Name] {
+			continue
+		}
+		var ruleIgnores []Ignore
+		if d.settings.Proactive {
+			ruleIgnores = v.ProactiveIgnores(dir, entries)
+		} else {
+			ruleIgnores = v.Ignores(dir, entries)
+		}
+		for _, ignore := range ruleIgnores {
+			ignore.Deletable = ignore.Deletable && v.Deletable
+			ignore.rule = v.Name
+			found = append(found, ignore)
+		}
+	}
+	// names not scanned further
+	var skipNames []string
+	for _, v := range found {
+		if !v.Negate && !v.Descend {
+			skipNames = append(skipNames, v.Name)
 		}
 	}
+	var ignores []Ignore
+	for _, v := range found {
+		if !v.Negate && coveredByParent(v.Name, skipNames) {
+			// e.g. node_modules/.vite when node_modules is ignored
+			continue
+		}
+		var ignorePath = parentsDir + "/" + v.Name
+		if slices.Contains(skipNames, v.Name) {
+			d.ignoredPaths[ignorePath] = true
+		}
+		v.Name = strings.TrimPrefix(ignorePath, "/")
+		ignores = append(ignores, v)
+	}
+
+	if d.settings.MaxEntries > 0 && len(entries) > d.settings.MaxEntries {
+		d.logger.Warnf("skip children of %s: %d entries, more than the limit of %d", dir, len(entries), d.settings.MaxEntries)
+		return ignores, nil
+	}
+	if d.settings.MaxDepth > 0 && strings.Count(parentsDir, "/") >= d.settings.MaxDepth {
+		d.logger.Debugf("skip children of %s: max depth reached", dir)
+		return ignores, nil
+	}
 
 	// scan child dir
 	for _, v := range entries {
-		if v.IsDir() && !ignoreNames[v.Name()] {
-			childIgnores, err := d.scanDir(filepath.Join(dir, v.Name()), parentsDir+"/"+v.Name())
-			if err != nil {
-				d.logger.Warnf("skip dir: %s, because: %s", dir, err.Error())
-				continue
-			}
-			ignores = append(ignores, childIgnores...)
+		isSymlink := isLink(v)
+		if !v.IsDir() && !isSymlink {
+			continue
+		}
+		if d.ignoredPaths[parentsDir+"/"+v.Name()] || matchesGlob(v.Name(), skipNames) ||
+			matchesGlob(v.Name(), d.globalIgnores) || d.stopAt(dir, v) {
+			continue
+		}
+		if isSymlink && !d.followSymlink(dir, v) {
+			continue
+		}
+		childIgnores, err := d.scanDir(filepath.Join(dir, v.Name()), parentsDir+"/"+v.Name(), disabled)
+		if err != nil {
+			d.logger.Warnf("skip dir: %s, because: %s", dir, err.Error())
+			continue
 		}
+		ignores = append(ignores, childIgnores...)
 	}
 
 	return ignores, nil
@@ -0,0 +1,30 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// deviceID is not available on this platform, mount points are not
+// detected.
+func deviceID(_ os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// fileKey identifies the directory at path by its resolved path.
+func fileKey(path string, _ os.FileInfo) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
+
+// isLink reports whether entry is a symlink. Windows junctions and other
+// reparse points are reported as irregular files since Go 1.23, they are
+// treated like symlinks.
+func isLink(entry os.DirEntry) bool {
+	return entry.Type()&(os.ModeSymlink|os.ModeIrregular) != 0
+}
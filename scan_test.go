@@ -0,0 +1,454 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// makeTree creates files in a temp dir, paths ending with "/" are dirs.
+func makeTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, p := range paths {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if strings.HasSuffix(p, "/") {
+			if err := os.MkdirAll(full, 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	return root
+}
+
+// scanTree scans root with rules and returns the generated ignores.
+func scanTree(t *testing.T, root string, rules []IgnoreRule) []string {
+	t.Helper()
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	scanner := NewDirScanner(rules, "")
+	scanner.SetRemoveD(true)
+	ignores, err := scanner.ScanFolder(root, stIgnore)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	return ignores
+}
+
+func TestScanAndroidGradle(t *testing.T) {
+	root := makeTree(t, "app/build.gradle", "app/build/outputs/", "app/.gradle/")
+	android, _ := findRule("android")
+	gradle, _ := findRule("gradle")
+	got := scanTree(t, root, []IgnoreRule{android, gradle})
+	expected := []string{"/app/build", "/app/.gradle"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestScanDisabledRules(t *testing.T) {
+	root := makeTree(t,
+		"app/build.gradle", "app/build/",
+		"lib/build.gradle", "lib/build/",
+		"skip/.particle-skip", "skip/c/Cargo.toml", "skip/c/Cargo.lock", "skip/c/target/",
+		"only/.particle-skip", "only/build.gradle", "only/build/", "only/Cargo.toml", "only/Cargo.lock",
+	)
+	err := os.WriteFile(filepath.Join(root, "only", ParticleSkipFile), []byte("# keep build\nandroid\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write skip file: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(root, ".stignore"), []byte(ParticleDisableDirective+" android /app\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write .stignore: %v", err)
+	}
+
+	got := scanTree(t, root, defaultConfig().FolderSettings(syncFolder{}).Rules(StIgnoreRules))
+	expected := []string{"/lib/build", "/only/target"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestScanGlobalPatterns(t *testing.T) {
+	root := makeTree(t, "Cargo.toml", "Cargo.lock", "target/", "$RECYCLE.BIN/sub/Cargo.toml", "$RECYCLE.BIN/sub/Cargo.lock", "$RECYCLE.BIN/sub/target/")
+	junk, _ := findRule("junk")
+	rust, _ := findRule("rust")
+
+	got := scanTree(t, root, []IgnoreRule{rust, junk})
+	expected := append(slices.Clone(junkPatterns), "/target")
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+
+	// with the default '(?d)' prefix, global patterns stay unanchored
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	got, err = NewDirScanner([]IgnoreRule{rust, junk}, "").ScanFolder(root, stIgnore)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	expected = nil
+	for _, pattern := range junkPatterns {
+		expected = append(expected, "(?d)"+pattern)
+	}
+	expected = append(expected, "(?d)/target")
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+
+	err = os.WriteFile(filepath.Join(root, ".stignore"), []byte(ParticleDisableDirective+" junk\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write .stignore: %v", err)
+	}
+	got = scanTree(t, root, []IgnoreRule{rust, junk})
+	expected = []string{"/target", "/$RECYCLE.BIN/sub/target"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestScanIgnoreFlags(t *testing.T) {
+	root := makeTree(t, "out/keep/", "out/tmp/", "out/keep/a.txt", "Cache/")
+	rule := IgnoreRule{Name: "test", Deletable: true, Detect: func(dir string, entry []os.DirEntry) []Ignore {
+		if filepath.Base(dir) == "out" {
+			return []Ignore{{Name: "keep", Negate: true, Priority: 1}}
+		}
+		if !hasEntryMatching(entry, "out") {
+			return nil
+		}
+		return []Ignore{
+			{Name: "cache", CaseInsensitive: true, Deletable: true},
+			{Name: "out", Descend: true, Deletable: true},
+		}
+	}}
+
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	got, err := NewDirScanner([]IgnoreRule{rule}, "").ScanFolder(root, stIgnore)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	expected := []string{"!/out/keep", "(?i)(?d)/cache", "(?d)/out"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestFolderPlanMigrate(t *testing.T) {
+	root := makeTree(t, "package.json", "node_modules/", "old/")
+	block := ParticleSeparatorLine + "\n(?d)/node_modules\n(?d)/old\n" + ParticleSeparatorLine + "\n"
+	err := os.WriteFile(filepath.Join(root, ".stignore"), []byte(block), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write .stignore: %v", err)
+	}
+	cfg := defaultConfig()
+	cfg.Rules.Deletable = map[string]bool{"nodejs": false}
+	plan, err := newApp(cfg).Plan(syncFolder{Root: root})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	plan.Migrate()
+	expected := []string{"/node_modules", "(?d)/old"}
+	if !reflect.DeepEqual(plan.proposed, expected) {
+		t.Errorf("Unexpected particle lines. Got %v, expected %v", plan.proposed, expected)
+	}
+}
+
+func TestFolderPlanManualEdits(t *testing.T) {
+	meta := blockMeta{Format: particleBlockFormat, Version: programVersion(), Hash: linesHash([]string{"(?d)/target"})}
+	block := meta.Line() + "\n(?d)/target\n/custom\n" + ParticleSeparatorLine + "\n"
+	plan := func(policy string) (*stIgnoreEdit, error) {
+		root := makeTree(t, "Cargo.toml", "Cargo.lock", "target/")
+		err := os.WriteFile(filepath.Join(root, ".stignore"), []byte(block), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write .stignore: %v", err)
+		}
+		cfg := defaultConfig()
+		cfg.ManualEdits = policy
+		p, err := newApp(cfg).Plan(syncFolder{Root: root})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.Apply(); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+		if err != nil {
+			t.Fatalf("Failed to read .stignore: %v", err)
+		}
+		if stIgnore.Edited() {
+			t.Errorf("Expected %s policy to write an unedited block", policy)
+		}
+		return stIgnore, nil
+	}
+
+	stIgnore, err := plan(editsPin)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if got := stIgnore.PinnedLines(); !reflect.DeepEqual(got, []string{"/custom"}) {
+		t.Errorf("Unexpected pinned lines. Got %v, expected [/custom]", got)
+	}
+	if got := stIgnore.ParticleLines(); !reflect.DeepEqual(got, []string{"(?d)/target"}) {
+		t.Errorf("Unexpected particle lines. Got %v, expected [(?d)/target]", got)
+	}
+
+	stIgnore, err = plan(editsMove)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if got := stIgnore.BaseLines(); !reflect.DeepEqual(got, []string{"/custom"}) {
+		t.Errorf("Unexpected base lines. Got %v, expected [/custom]", got)
+	}
+	if got := stIgnore.PinnedLines(); len(got) != 0 {
+		t.Errorf("Expected no pinned lines, got %v", got)
+	}
+
+	_, err = plan(editsAbort)
+	if err == nil || !strings.Contains(err.Error(), "/custom") {
+		t.Errorf("Expected an error naming the edited line, got %v", err)
+	}
+}
+
+func TestFolderPlanRetention(t *testing.T) {
+	root := makeTree(t, "Cargo.toml", "Cargo.lock", "target/")
+	cfg := defaultConfig()
+	cfg.Retention.KeepRuns = 1
+	cfg.Retention.StateFile = filepath.Join(t.TempDir(), "state.json")
+	apply := func() *folderPlan {
+		p, err := newApp(cfg).Plan(syncFolder{Root: root})
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+		if _, err = p.Apply(); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		return p
+	}
+	apply()
+	if err := os.Remove(filepath.Join(root, "Cargo.lock")); err != nil {
+		t.Fatalf("Failed to remove Cargo.lock: %v", err)
+	}
+
+	if p := apply(); !reflect.DeepEqual(p.proposed, []string{"(?d)/target"}) {
+		t.Errorf("Expected /target to be retained, got %v", p.proposed)
+	}
+	state, err := loadState(cfg.Retention.StateFile)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if l := state.Folders[root]["(?d)/target"]; l.Runs != 1 {
+		t.Errorf("Expected 1 run in state, got %d", l.Runs)
+	}
+	if p := apply(); len(p.proposed) != 0 {
+		t.Errorf("Expected retention to expire, got %v", p.proposed)
+	}
+	state, _ = loadState(cfg.Retention.StateFile)
+	if _, ok := state.Folders[root]; ok {
+		t.Errorf("Expected folder to be removed from state, got %v", state.Folders[root])
+	}
+
+	cfg.Retention.KeepRuns = 10
+	if err = os.WriteFile(filepath.Join(root, "Cargo.lock"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create Cargo.lock: %v", err)
+	}
+	apply()
+	_ = os.Remove(filepath.Join(root, "Cargo.lock"))
+	p, err := newApp(cfg).Plan(syncFolder{Root: root})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if pruned := p.Prune(); !reflect.DeepEqual(pruned, []string{"(?d)/target"}) || len(p.proposed) != 0 {
+		t.Errorf("Unexpected prune. Got %v, left %v", pruned, p.proposed)
+	}
+}
+
+func TestFolderPlanRetentionManualEdits(t *testing.T) {
+	root := makeTree(t, "a/Cargo.toml", "a/Cargo.lock", "a/target/", "b/Cargo.toml", "b/Cargo.lock", "b/target/")
+	cfg := defaultConfig()
+	cfg.ManualEdits = editsPin
+	cfg.Retention.KeepRuns = 3
+	cfg.Retention.StateFile = filepath.Join(t.TempDir(), "state.json")
+	apply := func() *folderPlan {
+		p, err := newApp(cfg).Plan(syncFolder{Root: root})
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+		if _, err = p.Apply(); err != nil {
+			t.Fatalf("Failed to apply: %v", err)
+		}
+		return p
+	}
+	apply()
+	if err := os.Remove(filepath.Join(root, "a", "Cargo.lock")); err != nil {
+		t.Fatalf("Failed to remove Cargo.lock: %v", err)
+	}
+	apply()
+
+	stIgnorePath := filepath.Join(root, ".stignore")
+	content, err := os.ReadFile(stIgnorePath)
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	edited := strings.Replace(string(content), "(?d)/b/target\n", "(?d)/b/target\n/custom\n", 1)
+	if err = os.WriteFile(stIgnorePath, []byte(edited), 0644); err != nil {
+		t.Fatalf("Failed to edit .stignore: %v", err)
+	}
+	p := apply()
+	if got := p.stIgnore.PinnedLines(); !reflect.DeepEqual(got, []string{"/custom"}) {
+		t.Errorf("Expected only the edited line to be pinned, got %v", got)
+	}
+	if !slices.Contains(p.proposed, "(?d)/a/target") {
+		t.Errorf("Expected /a/target to be retained, got %v", p.proposed)
+	}
+}
+
+func TestFolderPlanRetentionDeletableChange(t *testing.T) {
+	root := makeTree(t, "Cargo.toml", "Cargo.lock", "target/")
+	cfg := defaultConfig()
+	cfg.Retention.KeepRuns = 5
+	cfg.Retention.StateFile = filepath.Join(t.TempDir(), "state.json")
+	plan := func() *folderPlan {
+		p, err := newApp(cfg).Plan(syncFolder{Root: root})
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+		return p
+	}
+	if _, err := plan().Apply(); err != nil {
+		t.Fatalf("Failed to apply: %v", err)
+	}
+
+	cfg.Rules.Deletable = map[string]bool{"rust": false}
+	if p := plan(); !reflect.DeepEqual(p.proposed, []string{"/target"}) || len(p.retained) != 0 {
+		t.Errorf("Expected the line to be replaced, got %v, retained %v", p.proposed, p.retained)
+	}
+	p := plan()
+	p.Migrate()
+	if !reflect.DeepEqual(p.proposed, []string{"/target"}) {
+		t.Errorf("Expected the line to be migrated, got %v", p.proposed)
+	}
+}
+
+func TestScanProactive(t *testing.T) {
+	root := makeTree(t, "a/Cargo.toml", "b/package.json", "c/Cargo.toml", "c/Cargo.lock", "c/target/")
+	rust, _ := findRule("rust")
+	nodejs, _ := findRule("nodejs")
+	scan := func(proactive bool) []string {
+		stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+		if err != nil {
+			t.Fatalf("Failed to read .stignore: %v", err)
+		}
+		scanner := NewDirScanner([]IgnoreRule{rust, nodejs}, "")
+		settings := defaultConfig().Scan
+		settings.Proactive = proactive
+		scanner.SetScanSettings(settings)
+		got, err := scanner.ScanFolder(root, stIgnore)
+		if err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		return got
+	}
+
+	if got, expected := scan(false), []string{"(?d)/c/target"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+	expected := []string{"(?d)/a/target", "(?d)/b/node_modules", "(?d)/c/target"}
+	if got := scan(true); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected proactive ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestScanStopConditions(t *testing.T) {
+	root := makeTree(t,
+		"a/Cargo.toml", "a/Cargo.lock", "a/target/",
+		".git/modules/x/Cargo.toml", ".git/modules/x/Cargo.lock", ".git/modules/x/target/",
+		"nested/.stfolder/", "nested/p/Cargo.toml", "nested/p/Cargo.lock", "nested/p/target/",
+		"deep/1/2/Cargo.toml", "deep/1/2/Cargo.lock", "deep/1/2/target/",
+	)
+	stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+	if err != nil {
+		t.Fatalf("Failed to read .stignore: %v", err)
+	}
+	rust, _ := findRule("rust")
+	scanner := NewDirScanner([]IgnoreRule{rust}, "")
+	scanner.SetRemoveD(true)
+	settings := defaultConfig().Scan
+	settings.MaxDepth = 2
+	scanner.SetScanSettings(settings)
+	got, err := scanner.ScanFolder(root, stIgnore)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if expected := []string{"/a/target"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
+
+func TestScanSymlinkPolicy(t *testing.T) {
+	root := makeTree(t, "real/Cargo.toml", "real/Cargo.lock", "real/target/", "app/")
+	if err := os.Symlink(filepath.Join(root, "real"), filepath.Join(root, "app", "linked")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "real", "loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	rust, _ := findRule("rust")
+	scan := func(policy string) []string {
+		stIgnore, err := NewstIgnoreEdit(filepath.Join(root, ".stignore"))
+		if err != nil {
+			t.Fatalf("Failed to read .stignore: %v", err)
+		}
+		scanner := NewDirScanner([]IgnoreRule{rust}, "")
+		scanner.SetRemoveD(true)
+		settings := defaultConfig().Scan
+		settings.Symlinks = policy
+		scanner.SetScanSettings(settings)
+		got, err := scanner.ScanFolder(root, stIgnore)
+		if err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	if got, expected := scan(symlinkSkip), []string{"/real/target"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+	// real is scanned once, either directly or through app/linked
+	if got := scan(symlinkFollow); len(got) != 1 {
+		t.Errorf("Expected one ignore, got %v", got)
+	}
+}
+
+func TestSymlinkRules(t *testing.T) {
+	root := makeTree(t, "WORKSPACE", "out/", "pkg/package.json", "store/")
+	for link, target := range map[string]string{"bazel-out": "out", "pkg/node_modules": "store", "notbazel": "out"} {
+		if err := os.Symlink(filepath.Join(root, target), filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+	}
+	bazel, _ := findRule("bazel")
+	pnpm, _ := findRule("pnpm")
+	got := scanTree(t, root, []IgnoreRule{bazel, pnpm})
+	slices.Sort(got)
+	if expected := []string{"/bazel-out", "/pkg/node_modules"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected ignores. Got %v, expected %v", got, expected)
+	}
+}
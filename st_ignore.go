@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/doraemonkeys/doraemon"
 	"github.com/syncthing/syncthing/lib/fs" // For fs.Filesystem
@@ -14,18 +16,61 @@ import (
 
 const ParticleSeparatorLine = "// ---------------- AUTO GENRATE BY PARTICLE ----------------"
 
+// ParticleDisableDirective in the base section turns rules off for the
+// folder or a subtree of it, e.g.
+//
+//	// particle:disable android
+//	// particle:disable rust,nodejs /vendor
+const ParticleDisableDirective = "// particle:disable"
+
+// ParticleIncludeFile holds the particle lines in include mode, .stignore
+// only gets an #include line for it. It ignores itself so it is not synced.
+const ParticleIncludeFile = ".stignore.particle"
+
+const particleIncludeLine = "#include " + ParticleIncludeFile
+
+// Markers of the pinned section of the particle lines. Its lines were
+// edited by hand and are kept as they are when the lines are regenerated.
+const (
+	particlePinnedStart = "// particle:pinned"
+	particlePinnedEnd   = "// particle:pinned-end"
+)
+
 type stIgnoreEdit struct {
 	baseLines            []string
 	particleLines        []string
 	stFileMd5Hex         []byte
 	filePath             string
 	particleLinesChanged bool
+	baseLinesChanged     bool
+	// write the particle lines to ParticleIncludeFile instead of a block
+	includeMode bool
+	// .stignore has the #include line, or the particle block
+	hasInclude bool
+	hasBlock   bool
+	// md5 of ParticleIncludeFile when read, nil if missing
+	includeMd5Hex []byte
+	// device that wrote the particle lines and when, in coordination mode
+	device  string
+	written time.Time
+	// lines of the pinned section
+	pinnedLines []string
+	// metadata of the particle lines when read
+	diskMeta blockMeta
+	// ruleSetHash and generatorOptions of the lines to write
+	rulesHash string
+	options   string
+	// ParticleIncludeFile is synced instead of ignoring itself, and
+	// whether it ignores itself now
+	syncInclude        bool
+	includeSelfIgnored bool
 }
 
 func NewstIgnoreEdit(filePath string) (*stIgnoreEdit, error) {
 	baseLines := make([]string, 0)
 	particleLines := make([]string, 0)
 	foundParticleSeparatorCount := 0
+	var meta blockMeta
 	if doraemon.FileIsExist(filePath).IsFalse() {
 		return &stIgnoreEdit{
 			baseLines:     baseLines,
@@ -52,22 +97,268 @@ func NewstIgnoreEdit(filePath string) (*stIgnoreEdit, error) {
 				if len(line) > 0 {
 					baseLines = append(baseLines, string(line))
 				}
-			} else {
+			} else if len(line) > 0 {
 				particleLines = append(particleLines, string(line))
 			}
 			continue
 		}
+		if foundParticleSeparatorCount == 0 {
+			meta = parseBlockMeta(string(line))
+		}
 		foundParticleSeparatorCount++
 	}
 	if foundParticleSeparatorCount != 0 && foundParticleSeparatorCount != 2 {
 		return nil, fmt.Errorf("invalid file format, found %d separator lines", foundParticleSeparatorCount)
 	}
-	return &stIgnoreEdit{
+	s := &stIgnoreEdit{
 		baseLines:     baseLines,
 		particleLines: particleLines,
 		stFileMd5Hex:  fileMd5,
 		filePath:      filePath,
-	}, nil
+		hasBlock:      len(particleLines) > 0,
+		diskMeta:      meta,
+	}
+	s.particleLines = s.takePinned(s.particleLines)
+	if slices.Contains(baseLines, particleIncludeLine) {
+		s.baseLines = slices.DeleteFunc(baseLines, func(line string) bool { return line == particleIncludeLine })
+		s.hasInclude = true
+		err = s.readIncludeFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.device, s.written = s.diskMeta.Device, s.diskMeta.Time
+	return s, nil
+}
+
+func (s *stIgnoreEdit) includeFilePath() string {
+	return filepath.Join(filepath.Dir(s.filePath), ParticleIncludeFile)
+}
+
+// readIncludeFile adds the lines of ParticleIncludeFile after the ones of
+// an inline block, both exist while migrating.
+func (s *stIgnoreEdit) readIncludeFile() error {
+	content, err := os.ReadFile(s.includeFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	s.includeMd5Hex, err = doraemon.ComputeMD5(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to compute file md5: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "/"+ParticleIncludeFile {
+			s.includeSelfIgnored = true
+			continue
+		}
+		if strings.Contains(line, ParticleSeparatorLine) {
+			s.diskMeta = parseBlockMeta(line)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "//") && line != particlePinnedStart && line != particlePinnedEnd {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	s.AddIgnores(s.takePinned(lines))
+	s.particleLinesChanged = false
+	return nil
+}
+
+// takePinned stores the lines of the pinned section and returns the
+// others.
+func (s *stIgnoreEdit) takePinned(lines []string) []string {
+	var rest []string
+	pinned := false
+	for _, line := range lines {
+		switch {
+		case line == particlePinnedStart:
+			pinned = true
+		case line == particlePinnedEnd:
+			pinned = false
+		case pinned:
+			if !slices.Contains(s.pinnedLines, line) {
+				s.pinnedLines = append(s.pinnedLines, line)
+			}
+		default:
+			rest = append(rest, line)
+		}
+	}
+	return rest
+}
+
+// pinnedSection returns the pinned lines with their markers, nil if
+// there are none.
+func (s *stIgnoreEdit) pinnedSection() []string {
+	if len(s.pinnedLines) == 0 {
+		return nil
+	}
+	lines := append([]string{particlePinnedStart}, s.pinnedLines...)
+	return append(lines, particlePinnedEnd)
+}
+
+// Edited reports whether the particle lines were edited by hand since
+// particle wrote them, from the hash in the block metadata.
+func (s *stIgnoreEdit) Edited() bool {
+	return s.diskMeta.Hash != "" && s.diskMeta.Hash != linesHash(s.particleLines)
+}
+
+// Pin moves lines from the particle lines to the pinned section.
+func (s *stIgnoreEdit) Pin(lines []string) {
+	for _, line := range lines {
+		if !slices.Contains(s.pinnedLines, line) {
+			s.pinnedLines = append(s.pinnedLines, line)
+			s.particleLinesChanged = true
+		}
+	}
+	s.RemoveIgnores(lines)
+}
+
+// PinnedLines returns a copy of the lines in the pinned section.
+func (s *stIgnoreEdit) PinnedLines() []string {
+	return slices.Clone(s.pinnedLines)
+}
+
+// DiskMeta returns the block metadata of the particle lines when read.
+func (s *stIgnoreEdit) DiskMeta() blockMeta {
+	return s.diskMeta
+}
+
+// SetWriter records the device writing the particle lines and when, an
+// empty device removes them from the block metadata.
+func (s *stIgnoreEdit) SetWriter(device string, t time.Time) {
+	if device == "" {
+		t = time.Time{}
+	}
+	s.device, s.written = device, t
+}
+
+// SetGenerator records the rules and options generating the lines, they
+// are written in the block metadata.
+func (s *stIgnoreEdit) SetGenerator(rulesHash, options string) {
+	s.rulesHash = rulesHash
+	s.options = options
+}
+
+// meta returns the metadata to write with the particle lines. The
+// version is only refreshed when what it describes changes, so another
+// build does not rewrite the block.
+func (s *stIgnoreEdit) meta() blockMeta {
+	m := blockMeta{
+		Format:  particleBlockFormat,
+		Version: s.diskMeta.Version,
+		Rules:   s.rulesHash,
+		Hash:    linesHash(s.particleLines),
+		Options: s.options,
+		Device:  s.device,
+		Time:    s.written,
+	}
+	if !m.Same(s.diskMeta) {
+		m.Version = programVersion()
+	}
+	return m
+}
+
+// Stale tells from the block metadata, without scanning, whether the
+// particle lines need to be regenerated and why.
+func (s *stIgnoreEdit) Stale(rulesHash, options string) (bool, string) {
+	switch {
+	case len(s.particleLines) == 0:
+		return true, "no particle lines"
+	case s.diskMeta.Format < particleBlockFormat:
+		return true, fmt.Sprintf("written in an older format (%d)", s.diskMeta.Format)
+	case s.diskMeta.Hash != linesHash(s.particleLines):
+		return true, "particle lines edited"
+	case s.diskMeta.Rules != rulesHash:
+		return true, "rules changed"
+	case s.diskMeta.Options != options:
+		return true, "options changed"
+	}
+	return false, ""
+}
+
+// SetIncludeMode chooses between writing the particle lines to
+// ParticleIncludeFile or to a block in .stignore. The other layout is
+// migrated on the next SetChange.
+func (s *stIgnoreEdit) SetIncludeMode(include bool) {
+	s.includeMode = include
+}
+
+// SetSyncIncludeFile controls whether ParticleIncludeFile is synced, for
+// devices coordinating their particle lines. Otherwise it ignores itself.
+func (s *stIgnoreEdit) SetSyncIncludeFile(sync bool) {
+	s.syncInclude = sync
+}
+
+// includeFileContent returns the content of ParticleIncludeFile.
+func (s *stIgnoreEdit) includeFileContent() []byte {
+	var b bytes.Buffer
+	b.WriteString(s.meta().Line() + "\n")
+	if !s.syncInclude {
+		b.WriteString("/" + ParticleIncludeFile + "\n")
+	}
+	for _, line := range append(s.pinnedSection(), s.particleLines...) {
+		b.WriteString(line + "\n")
+	}
+	return b.Bytes()
+}
+
+// checkIncludeFile fails if ParticleIncludeFile changed since it was read.
+func (s *stIgnoreEdit) checkIncludeFile() error {
+	if s.includeMd5Hex == nil {
+		return nil
+	}
+	fileMd5, err := doraemon.ComputeFileMd5(s.includeFilePath())
+	if err == nil && !bytes.Equal(fileMd5, s.includeMd5Hex) {
+		return fmt.Errorf("file md5 mismatch, %s has been modified", s.includeFilePath())
+	}
+	return nil
+}
+
+// writeIncludeFile writes the particle lines to ParticleIncludeFile.
+func (s *stIgnoreEdit) writeIncludeFile() (updated bool, err error) {
+	err = s.checkIncludeFile()
+	if err != nil {
+		return false, err
+	}
+	content := s.includeFileContent()
+	contentMd5, err := doraemon.ComputeMD5(bytes.NewReader(content))
+	if err != nil {
+		return false, fmt.Errorf("failed to compute file md5: %w", err)
+	}
+	if bytes.Equal(contentMd5, s.includeMd5Hex) {
+		return false, nil
+	}
+	err = os.WriteFile(s.includeFilePath(), content, 0666)
+	if err != nil {
+		return false, fmt.Errorf("failed to write file: %w", err)
+	}
+	s.includeMd5Hex = contentMd5
+	s.includeSelfIgnored = !s.syncInclude
+	return true, nil
+}
+
+// removeIncludeFile removes ParticleIncludeFile once .stignore does not
+// include it anymore.
+func (s *stIgnoreEdit) removeIncludeFile() error {
+	err := s.checkIncludeFile()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.includeFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	s.includeMd5Hex = nil
+	return nil
 }
 
 func (s *stIgnoreEdit) createEmptyFile() error {
@@ -87,7 +378,36 @@ func (s *stIgnoreEdit) SetChange() (updated bool, err error) {
 	if !s.NeedUpdate() {
 		return false, nil
 	}
-	if len(s.baseLines) == 0 && len(s.particleLines) == 0 {
+	wantInclude, wantBlock := s.wantLayout()
+	if wantInclude {
+		// written before .stignore includes it
+		updated, err = s.writeIncludeFile()
+		if err != nil {
+			return false, err
+		}
+	}
+	stUpdated, err := s.writeStIgnore(wantInclude, wantBlock)
+	if err != nil {
+		return updated || stUpdated, err
+	}
+	if !wantInclude && s.hasInclude {
+		err = s.removeIncludeFile()
+		if err != nil {
+			return true, err
+		}
+		updated = true
+	}
+	s.hasInclude, s.hasBlock = wantInclude, wantBlock
+	s.diskMeta = s.meta()
+	s.particleLinesChanged = false
+	s.baseLinesChanged = false
+	return updated || stUpdated, nil
+}
+
+// writeStIgnore writes the base lines, followed by the #include line or
+// the particle block.
+func (s *stIgnoreEdit) writeStIgnore(wantInclude, wantBlock bool) (updated bool, err error) {
+	if len(s.baseLines) == 0 && !wantInclude && !wantBlock {
 		if doraemon.FileOrDirIsExist(s.filePath) {
 			_ = os.Remove(s.filePath)
 			return true, nil
@@ -114,16 +434,22 @@ func (s *stIgnoreEdit) SetChange() (updated bool, err error) {
 			return false, fmt.Errorf("failed to write line: %w", err)
 		}
 	}
+	if wantInclude {
+		_, err = writer.WriteString(particleIncludeLine + "\n")
+		if err != nil {
+			return false, fmt.Errorf("failed to write include line: %w", err)
+		}
+	}
 	err = writer.WriteByte('\n')
 	if err != nil {
 		return false, fmt.Errorf("failed to write newline: %w", err)
 	}
-	if len(s.particleLines) > 0 {
-		_, err = writer.WriteString(ParticleSeparatorLine + "\n")
+	if wantBlock {
+		_, err = writer.WriteString(s.meta().Line() + "\n")
 		if err != nil {
 			return false, fmt.Errorf("failed to write separator line: %w", err)
 		}
-		for _, line := range s.particleLines {
+		for _, line := range append(s.pinnedSection(), s.particleLines...) {
 			_, err := writer.WriteString(line + "\n")
 			if err != nil {
 				return false, fmt.Errorf("failed to write line: %w", err)
@@ -182,7 +508,6 @@ func (s *stIgnoreEdit) SetChange() (updated bool, err error) {
 		return true, fmt.Errorf("failed to compute file md5: %w", err)
 	}
 	s.stFileMd5Hex = newFileMd5
-	s.particleLinesChanged = false
 	return true, nil
 }
 
@@ -191,6 +516,9 @@ func (s *stIgnoreEdit) AddIgnores(ignores []string) bool {
 	for _, line := range s.particleLines {
 		linesMap[line] = true
 	}
+	for _, line := range s.pinnedLines {
+		linesMap[line] = true
+	}
 	added := false
 	for _, ignore := range ignores {
 		if _, ok := linesMap[ignore]; !ok {
@@ -203,16 +531,115 @@ func (s *stIgnoreEdit) AddIgnores(ignores []string) bool {
 }
 
 func (s *stIgnoreEdit) OverwriteIgnores(ignores []string) {
+	oldLines := s.particleLines
 	s.particleLines = make([]string, 0, len(ignores))
 	s.AddIgnores(ignores)
+	s.particleLinesChanged = !slices.Equal(oldLines, s.particleLines)
+}
+
+// RemoveIgnores removes lines from the particle lines.
+func (s *stIgnoreEdit) RemoveIgnores(lines []string) {
+	oldLen := len(s.particleLines)
+	s.particleLines = slices.DeleteFunc(s.particleLines, func(line string) bool {
+		return slices.Contains(lines, line)
+	})
+	if len(s.particleLines) != oldLen {
+		s.particleLinesChanged = true
+	}
 }
 
 func (s *stIgnoreEdit) NeedUpdate() bool {
-	return s.particleLinesChanged
+	wantInclude, wantBlock := s.wantLayout()
+	if wantInclude && s.hasInclude && s.includeSelfIgnored == s.syncInclude {
+		return true
+	}
+	if (wantInclude || wantBlock) && !s.diskMeta.Same(s.meta()) {
+		return true
+	}
+	return s.particleLinesChanged || s.baseLinesChanged || wantInclude != s.hasInclude || wantBlock != s.hasBlock
 }
-func (s *stIgnoreEdit) GetBaseIgnoreCheckFunc() func(path string) bool {
-	baseIgnores := bytes.NewBuffer(nil)
+
+// wantLayout returns where the particle lines go when written.
+func (s *stIgnoreEdit) wantLayout() (include bool, block bool) {
+	if len(s.particleLines) == 0 && len(s.pinnedLines) == 0 {
+		return false, false
+	}
+	return s.includeMode, !s.includeMode
+}
+
+// ParticleLines returns a copy of the lines in the particle block.
+func (s *stIgnoreEdit) ParticleLines() []string {
+	return slices.Clone(s.particleLines)
+}
+
+// BaseLines returns a copy of the user written lines.
+func (s *stIgnoreEdit) BaseLines() []string {
+	return slices.Clone(s.baseLines)
+}
+
+// MergeBaseLines appends the lines missing from the user written lines
+// and returns them.
+func (s *stIgnoreEdit) MergeBaseLines(lines []string) []string {
+	var added []string
+	for _, line := range lines {
+		if line == "" || slices.Contains(s.baseLines, line) {
+			continue
+		}
+		s.baseLines = append(s.baseLines, line)
+		added = append(added, line)
+	}
+	if len(added) > 0 {
+		s.baseLinesChanged = true
+	}
+	return added
+}
+
+// DisabledRules returns the rules turned off by directives in the base
+// section, keyed by subtree: "" for the whole folder, "/a/b" for a child.
+func (s *stIgnoreEdit) DisabledRules() map[string][]string {
+	disabled := make(map[string][]string)
 	for _, line := range s.baseLines {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), ParticleDisableDirective)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || len(fields) > 2 {
+			logger.Warnf("invalid directive in %s: %s", s.filePath, line)
+			continue
+		}
+		var subtree string
+		if len(fields) == 2 {
+			subtree = strings.Trim(filepath.ToSlash(fields[1]), "/")
+			if subtree != "" {
+				subtree = "/" + subtree
+			}
+		}
+		for _, name := range strings.Split(fields[0], ",") {
+			if name == "" {
+				continue
+			}
+			if _, ok := findRule(name); !ok && name != allRules {
+				logger.Warnf("unknown rule %q in %s: %s", name, s.filePath, line)
+			}
+			disabled[subtree] = append(disabled[subtree], name)
+		}
+	}
+	return disabled
+}
+
+// FilePath returns the file the particle lines are written to.
+func (s *stIgnoreEdit) FilePath() string {
+	if s.includeMode {
+		return s.includeFilePath()
+	}
+	return s.filePath
+}
+
+func (s *stIgnoreEdit) GetBaseIgnoreCheckFunc() (func(path string) bool, error) {
+	baseIgnores := bytes.NewBuffer(nil)
+	// pinned lines are user written too
+	for _, line := range append(slices.Clone(s.baseLines), s.pinnedLines...) {
 		baseIgnores.WriteString(line + "\n")
 	}
 	rootDir := filepath.Dir(s.filePath)
@@ -221,7 +648,7 @@ func (s *stIgnoreEdit) GetBaseIgnoreCheckFunc() func(path string) bool {
 
 	err := matcher.Parse(baseIgnores, ".stignore")
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to parse %s: %w", s.filePath, err)
 	}
 	return func(path string) bool {
 		path = filepath.ToSlash(path)
@@ -229,5 +656,5 @@ func (s *stIgnoreEdit) GetBaseIgnoreCheckFunc() func(path string) bool {
 		path = strings.TrimPrefix(path, rootDir)
 		path = strings.Trim(path, "/")
 		return matcher.Match(path).CanSkipDir()
-	}
+	}, nil
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/doraemonkeys/doraemon"
 )
@@ -115,7 +118,8 @@ func TestWriteToFile(t *testing.T) {
 		t.Fatalf("Failed to read written file: %v", err)
 	}
 
-	expectedContent := "base1\nbase2\n\n" + ParticleSeparatorLine + "\nparticle1\nparticle2\n\n" + ParticleSeparatorLine + "\n"
+	meta := blockMeta{Format: particleBlockFormat, Version: programVersion(), Hash: linesHash([]string{"particle1", "particle2"})}
+	expectedContent := "base1\nbase2\n\n" + meta.Line() + "\nparticle1\nparticle2\n\n" + ParticleSeparatorLine + "\n"
 	if string(content) != expectedContent {
 		t.Errorf("Unexpected file content. Got:\n%s\nExpected:\n%s", content, expectedContent)
 	}
@@ -179,3 +183,150 @@ func TestWriteToFileWithModification(t *testing.T) {
 		t.Fatalf("Expected error when writing to modified file, got nil")
 	}
 }
+
+func TestIncludeMode(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, ".stignore")
+	includePath := filepath.Join(dir, ParticleIncludeFile)
+	meta := blockMeta{Format: particleBlockFormat, Version: programVersion(), Hash: linesHash([]string{"particle1", "particle2"})}
+	inline := "base1\n\n" + meta.Line() + "\nparticle1\nparticle2\n\n" + ParticleSeparatorLine + "\n"
+	err := os.WriteFile(filePath, []byte(inline), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// an inline block is moved to the include file
+	sie, err := NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sie.SetIncludeMode(true)
+	if updated, err := sie.SetChange(); err != nil || !updated {
+		t.Fatalf("Expected update, got %v, %v", updated, err)
+	}
+	content, _ := os.ReadFile(filePath)
+	if expected := "base1\n" + particleIncludeLine + "\n\n"; string(content) != expected {
+		t.Errorf("Unexpected file content. Got:\n%s\nExpected:\n%s", content, expected)
+	}
+	content, _ = os.ReadFile(includePath)
+	if expected := meta.Line() + "\n/" + ParticleIncludeFile + "\nparticle1\nparticle2\n"; string(content) != expected {
+		t.Errorf("Unexpected include file content. Got:\n%s\nExpected:\n%s", content, expected)
+	}
+
+	sie, err = NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(sie.BaseLines(), []string{"base1"}) || !reflect.DeepEqual(sie.ParticleLines(), []string{"particle1", "particle2"}) {
+		t.Errorf("Unexpected lines: %v, %v", sie.BaseLines(), sie.ParticleLines())
+	}
+	sie.SetIncludeMode(true)
+	if sie.NeedUpdate() {
+		t.Errorf("Expected no update needed")
+	}
+
+	// and back to an inline block
+	sie.SetIncludeMode(false)
+	if updated, err := sie.SetChange(); err != nil || !updated {
+		t.Fatalf("Expected update, got %v, %v", updated, err)
+	}
+	content, _ = os.ReadFile(filePath)
+	if string(content) != inline {
+		t.Errorf("Unexpected file content. Got:\n%s\nExpected:\n%s", content, inline)
+	}
+	if _, err := os.Stat(includePath); !os.IsNotExist(err) {
+		t.Errorf("Expected include file to be removed, got %v", err)
+	}
+}
+
+func TestBlockMetaStale(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".stignore")
+	legacy := "base1\n" + ParticleSeparatorLine + "\nparticle1\n" + ParticleSeparatorLine + "\n"
+	err := os.WriteFile(filePath, []byte(legacy), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	sie, err := NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stale, reason := sie.Stale("rules", "opts"); !stale || reason != "written in an older format (1)" {
+		t.Errorf("Expected legacy block to be stale, got %v %q", stale, reason)
+	}
+
+	sie.SetGenerator("rules", "opts")
+	if updated, err := sie.SetChange(); err != nil || !updated {
+		t.Fatalf("Expected update, got %v, %v", updated, err)
+	}
+	sie, err = NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stale, reason := sie.Stale("rules", "opts"); stale {
+		t.Errorf("Expected block to be up to date, got %q", reason)
+	}
+	if stale, reason := sie.Stale("other", "opts"); !stale || reason != "rules changed" {
+		t.Errorf("Expected rules changed, got %v %q", stale, reason)
+	}
+
+	content, _ := os.ReadFile(filePath)
+	err = os.WriteFile(filePath, []byte(strings.Replace(string(content), "particle1", "edited", 1)), 0644)
+	if err != nil {
+		t.Fatalf("Failed to edit test file: %v", err)
+	}
+	sie, err = NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stale, reason := sie.Stale("rules", "opts"); !stale || reason != "particle lines edited" {
+		t.Errorf("Expected particle lines edited, got %v %q", stale, reason)
+	}
+}
+
+func TestParseBlockMeta(t *testing.T) {
+	written := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	meta := blockMeta{Format: particleBlockFormat, Version: "v1.4.0", Rules: "1a2b", Hash: "3c4d", Options: "removeD:false", Device: "ABC-123", Time: written}
+	if got := parseBlockMeta(meta.Line()); got != meta {
+		t.Errorf("Unexpected metadata. Got %+v, expected %+v", got, meta)
+	}
+	tests := []struct {
+		line string
+		want blockMeta
+	}{
+		{ParticleSeparatorLine, blockMeta{Format: 1}},
+	}
+	for _, tt := range tests {
+		if got := parseBlockMeta(tt.line); got != tt.want {
+			t.Errorf("Unexpected metadata of %q. Got %+v, expected %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestBlockMetaOtherBuild(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), ".stignore")
+	meta := blockMeta{Format: particleBlockFormat, Version: "v0.0.1", Rules: "rules", Hash: linesHash([]string{"particle1"}), Options: "opts"}
+	content := "base1\n\n" + meta.Line() + "\nparticle1\n\n" + ParticleSeparatorLine + "\n"
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	sie, err := NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	sie.SetGenerator("rules", "opts")
+	if sie.NeedUpdate() {
+		t.Errorf("Expected a block written by another build not to need an update")
+	}
+
+	sie.AddIgnores([]string{"particle2"})
+	if _, err = sie.SetChange(); err != nil {
+		t.Fatalf("Failed to write to file: %v", err)
+	}
+	sie, err = NewstIgnoreEdit(filePath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if v := sie.DiskMeta().Version; v != programVersion() {
+		t.Errorf("Expected the version to be refreshed with the lines, got %q", v)
+	}
+}
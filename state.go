@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retainedLine is a particle line kept by retention after its project
+// was not detected anymore.
+type retainedLine struct {
+	// first run the line was not detected
+	Since time.Time `json:"since"`
+	// apply runs the line was not detected in
+	Runs int `json:"runs"`
+}
+
+// retentionState is the state file of retention, shared by all folders.
+type retentionState struct {
+	// folder root -> particle line -> retention
+	Folders map[string]map[string]retainedLine `json:"folders"`
+
+	path string
+}
+
+// DefaultStatePath returns the state file in the user cache dir,
+// e.g. ~/.cache/particle/state.json.
+func DefaultStatePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "particle", "state.json"), nil
+}
+
+// loadState reads the state file, a missing file is an empty state.
+func loadState(path string) (*retentionState, error) {
+	state := &retentionState{Folders: make(map[string]map[string]retainedLine), path: path}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	err = json.Unmarshal(content, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if state.Folders == nil {
+		state.Folders = make(map[string]map[string]retainedLine)
+	}
+	return state, nil
+}
+
+// Update replaces the retained lines of the folder and writes the state
+// file.
+func (s *retentionState) Update(root string, lines map[string]retainedLine) error {
+	if len(lines) == 0 {
+		if _, ok := s.Folders[root]; !ok {
+			return nil
+		}
+		delete(s.Folders, root)
+	} else {
+		s.Folders[root] = lines
+	}
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	err = os.WriteFile(s.path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}